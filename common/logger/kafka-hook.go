@@ -0,0 +1,91 @@
+/*
+ * Copyright 2019-present Open Networking Foundation
+
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+
+ * http://www.apache.org/licenses/LICENSE-2.0
+
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package logger
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/Shopify/sarama"
+	log "github.com/sirupsen/logrus"
+)
+
+// KafkaHook publishes log entries to the kafka topics listed in the
+// "topics" field of the entry, like github.com/gfremex/logrus-kafka-hook
+// which it replaces: that hook builds its own sarama config, so its producer
+// cannot use TLS nor SASL.
+type KafkaHook struct {
+	levels    []log.Level
+	formatter log.Formatter
+	producer  sarama.AsyncProducer
+}
+
+func NewKafkaHook(levels []log.Level, formatter log.Formatter, brokers []string, config *sarama.Config) (*KafkaHook, error) {
+	if config == nil {
+		config = sarama.NewConfig()
+	}
+	config.Producer.RequiredAcks = sarama.WaitForLocal
+	config.Producer.Compression = sarama.CompressionSnappy
+	config.Producer.Flush.Frequency = 500 * time.Millisecond
+
+	producer, err := sarama.NewAsyncProducer(brokers, config)
+	if err != nil {
+		return nil, err
+	}
+
+	// the logger can't log its own failures
+	go func() {
+		for err := range producer.Errors() {
+			fmt.Fprintf(os.Stderr, "Failed to send log entry to kafka: %s\n", err)
+		}
+	}()
+
+	return &KafkaHook{
+		levels:    levels,
+		formatter: formatter,
+		producer:  producer,
+	}, nil
+}
+
+func (h *KafkaHook) Levels() []log.Level {
+	return h.levels
+}
+
+func (h *KafkaHook) Fire(entry *log.Entry) error {
+	field, ok := entry.Data["topics"]
+	if !ok {
+		return errors.New("field topics not found")
+	}
+	topics, ok := field.([]string)
+	if !ok {
+		return errors.New("field topics must be []string")
+	}
+
+	b, err := h.formatter.Format(entry)
+	if err != nil {
+		return err
+	}
+	for _, topic := range topics {
+		h.producer.Input() <- &sarama.ProducerMessage{
+			Topic: topic,
+			Value: sarama.ByteEncoder(b),
+		}
+	}
+	return nil
+}
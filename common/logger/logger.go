@@ -17,7 +17,7 @@
 package logger
 
 import (
-	lkh "github.com/gfremex/logrus-kafka-hook"
+	"github.com/Shopify/sarama"
 	log "github.com/sirupsen/logrus"
 	"time"
 )
@@ -27,6 +27,12 @@ var (
 )
 
 func Setup(kafkaBroker string, level string) {
+	SetupWithKafkaConfig(kafkaBroker, nil, level)
+}
+
+// SetupWithKafkaConfig is Setup sending the logs to kafka with the given
+// sarama config, e.g. with TLS and SASL enabled, nil for the defaults
+func SetupWithKafkaConfig(kafkaBroker string, kafkaConfig *sarama.Config, level string) {
 
 	logger := log.New()
 	//logger.SetReportCaller(true)
@@ -50,8 +56,7 @@ func Setup(kafkaBroker string, level string) {
 
 	if len(kafkaBroker) > 0 {
 		myLogger.Debug("Setting up kafka integration")
-		hook, err := lkh.NewKafkaHook(
-			"kh",
+		hook, err := NewKafkaHook(
 			[]log.Level{log.DebugLevel, log.InfoLevel, log.WarnLevel, log.ErrorLevel},
 			&log.JSONFormatter{
 				TimestampFormat: time.RFC3339Nano,
@@ -62,10 +67,12 @@ func Setup(kafkaBroker string, level string) {
 				},
 			},
 			[]string{kafkaBroker},
+			kafkaConfig,
 		)
 
 		if err != nil {
 			myLogger.Error(err)
+			return
 		}
 
 		logger.Hooks.Add(hook)
@@ -0,0 +1,193 @@
+// Copyright 2019 Open Networking Foundation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	initialReconnectBackoff = time.Second
+	maxReconnectBackoff     = time.Minute
+)
+
+var (
+	brokerUp = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "kafka_topic_exporter_broker_up",
+			Help: "Whether the consumer of the cluster is connected and joined its group (1) or not (0)",
+		},
+		[]string{"cluster"},
+	)
+	consumerReconnects = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "kafka_topic_exporter_consumer_reconnects_total",
+			Help: "Number of times the consumer of the cluster reconnected to the broker",
+		},
+		[]string{"cluster"},
+	)
+
+	connections = newConnectionStatus()
+)
+
+// backoff computes exponentially growing delays between reconnections
+type backoff struct {
+	current time.Duration
+}
+
+func (b *backoff) next() time.Duration {
+	if b.current == 0 {
+		b.current = initialReconnectBackoff
+	} else if b.current *= 2; b.current > maxReconnectBackoff {
+		b.current = maxReconnectBackoff
+	}
+	return b.current
+}
+
+func (b *backoff) reset() {
+	b.current = 0
+}
+
+// wait blocks for the given delay, it returns false if the context was
+// cancelled in the meantime
+func wait(ctx context.Context, delay time.Duration) bool {
+	select {
+	case <-ctx.Done():
+		return false
+	case <-time.After(delay):
+		return true
+	}
+}
+
+// connectionStatus tracks which topics are consumed by a connected group
+// member, it backs the readiness endpoint
+type connectionStatus struct {
+	mu sync.Mutex
+	// broker -> topic -> connected
+	listeners map[string]map[string]bool
+	// broker/topic[partition] -> lag, of the partitions lagging behind more
+	// than the max_lag of their broker
+	lagging map[string]int64
+}
+
+func newConnectionStatus() *connectionStatus {
+	return &connectionStatus{
+		listeners: make(map[string]map[string]bool),
+		lagging:   make(map[string]int64),
+	}
+}
+
+func (c *connectionStatus) set(broker string, topic string, connected bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	topics, ok := c.listeners[broker]
+	if !ok {
+		topics = make(map[string]bool)
+		c.listeners[broker] = topics
+	}
+	topics[topic] = connected
+}
+
+// remove forgets a topic listener which was stopped
+func (c *connectionStatus) remove(broker string, topic string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.listeners[broker], topic)
+
+	prefix := broker + "/" + topic + "["
+	for partition := range c.lagging {
+		if strings.HasPrefix(partition, prefix) {
+			delete(c.lagging, partition)
+		}
+	}
+}
+
+// setLag records the lag of a partition, maxLag 0 never lags behind
+func (c *connectionStatus) setLag(broker string, topic string, partition int32, lag int64, maxLag int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	key := fmt.Sprintf("%s/%s[%d]", broker, topic, partition)
+	if maxLag > 0 && lag > maxLag {
+		c.lagging[key] = lag
+	} else {
+		delete(c.lagging, key)
+	}
+}
+
+// disconnected returns the topic listeners which are not connected
+func (c *connectionStatus) disconnected() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var down []string
+	for broker, topics := range c.listeners {
+		for topic, connected := range topics {
+			if !connected {
+				down = append(down, broker+"/"+topic)
+			}
+		}
+	}
+	sort.Strings(down)
+	return down
+}
+
+// laggingBehind returns the partitions lagging behind, with their lag
+func (c *connectionStatus) laggingBehind() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var lagging []string
+	for partition, lag := range c.lagging {
+		lagging = append(lagging, fmt.Sprintf("%s (%d)", partition, lag))
+	}
+	sort.Strings(lagging)
+	return lagging
+}
+
+// healthHandler reports the exporter is alive, it does not depend on kafka
+// so that an unreachable broker doesn't get the exporter restarted
+func healthHandler(w http.ResponseWriter, r *http.Request) {
+	fmt.Fprintln(w, "ok")
+}
+
+// readyHandler reports whether every topic listener is connected and keeps
+// up with its partitions
+func readyHandler(w http.ResponseWriter, r *http.Request) {
+	down := connections.disconnected()
+	lagging := connections.laggingBehind()
+	if len(down) == 0 && len(lagging) == 0 {
+		fmt.Fprintln(w, "ok")
+		return
+	}
+
+	w.WriteHeader(http.StatusServiceUnavailable)
+	if len(down) > 0 {
+		fmt.Fprintf(w, "not connected: %s\n", strings.Join(down, ", "))
+	}
+	if len(lagging) > 0 {
+		fmt.Fprintf(w, "lagging behind: %s\n", strings.Join(lagging, ", "))
+	}
+}
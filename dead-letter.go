@@ -0,0 +1,155 @@
+// Copyright 2019 Open Networking Foundation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"strconv"
+	"sync"
+	"time"
+
+	"gerrit.opencord.org/kafka-topic-exporter/common/logger"
+	"github.com/Shopify/sarama"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	decodeErrors = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "kafka_topic_exporter_decode_errors_total",
+			Help: "Number of messages skipped because they could not be decoded",
+		},
+		[]string{"cluster", "topic", "reason"},
+	)
+	deadLetters = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "kafka_topic_exporter_dead_letters_total",
+			Help: "Number of skipped messages forwarded to the dead-letter topic",
+		},
+		[]string{"cluster", "topic"},
+	)
+	deadLettersDropped = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "kafka_topic_exporter_dead_letters_dropped_total",
+			Help: "Number of skipped messages which could not be forwarded to the dead-letter topic",
+		},
+		[]string{"cluster", "topic"},
+	)
+)
+
+// deadLetterQueue forwards the messages which could not be exported to a
+// dead-letter topic of the same cluster, with the error in the headers. It
+// never blocks consuming: messages are dropped when the producer cannot be
+// created or doesn't keep up
+type deadLetterQueue struct {
+	broker BrokerInfo
+	config *sarama.Config
+
+	// the producer is created on first use, so that an unreachable
+	// broker doesn't prevent consuming, and not retried before retryAt
+	// once its creation failed
+	mu       sync.Mutex
+	producer sarama.AsyncProducer
+	retry    backoff
+	retryAt  time.Time
+}
+
+func newDeadLetterQueue(broker BrokerInfo, config *sarama.Config) *deadLetterQueue {
+	if broker.DeadLetterTopic == "" {
+		return nil
+	}
+	return &deadLetterQueue{broker: broker, config: config}
+}
+
+// send forwards a message to the dead-letter topic, if one is configured
+func (q *deadLetterQueue) send(msg *sarama.ConsumerMessage, decoder string, err error) {
+	if q == nil {
+		return
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.producer == nil && !q.connect() {
+		deadLettersDropped.WithLabelValues(q.broker.Name, msg.Topic).Inc()
+		return
+	}
+
+	select {
+	case q.producer.Input() <- &sarama.ProducerMessage{
+		Topic: q.broker.DeadLetterTopic,
+		Key:   sarama.ByteEncoder(msg.Key),
+		Value: sarama.ByteEncoder(msg.Value),
+		Headers: []sarama.RecordHeader{
+			{Key: []byte("error"), Value: []byte(err.Error())},
+			{Key: []byte("error_reason"), Value: []byte(errorReason(err))},
+			{Key: []byte("decoder"), Value: []byte(decoder)},
+			{Key: []byte("original_topic"), Value: []byte(msg.Topic)},
+			{Key: []byte("original_partition"), Value: []byte(strconv.Itoa(int(msg.Partition)))},
+			{Key: []byte("original_offset"), Value: []byte(strconv.FormatInt(msg.Offset, 10))},
+		},
+	}:
+		deadLetters.WithLabelValues(q.broker.Name, msg.Topic).Inc()
+	default:
+		logger.Warn("Dead-letter topic [%s] is not keeping up, dropping message %s[%d]@%d",
+			q.broker.DeadLetterTopic, msg.Topic, msg.Partition, msg.Offset)
+		deadLettersDropped.WithLabelValues(q.broker.Name, msg.Topic).Inc()
+	}
+}
+
+// connect creates the producer, unless a previous attempt failed less than
+// a backoff ago. It is called with q.mu held
+func (q *deadLetterQueue) connect() bool {
+	if time.Now().Before(q.retryAt) {
+		return false
+	}
+	producer, err := sarama.NewAsyncProducer(q.broker.bootstrapHosts(), q.config)
+	if err != nil {
+		delay := q.retry.next()
+		q.retryAt = time.Now().Add(delay)
+		logger.Error("Cannot connect to dead-letter topic [%s], dropping messages for %s: %s",
+			q.broker.DeadLetterTopic, delay, err)
+		return false
+	}
+	go func() {
+		for err := range producer.Errors() {
+			logger.Error("Cannot forward message to [%s]: %s", q.broker.DeadLetterTopic, err)
+		}
+	}()
+	q.retry.reset()
+	q.producer = producer
+	return true
+}
+
+func (q *deadLetterQueue) close() {
+	if q == nil {
+		return
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.producer != nil {
+		if err := q.producer.Close(); err != nil {
+			logger.Error("%s", err)
+		}
+		q.producer = nil
+	}
+}
+
+// errorReason returns the reason label of an export error
+func errorReason(err error) string {
+	if e, ok := err.(*exportError); ok {
+		return e.reason
+	}
+	return "unknown"
+}
@@ -0,0 +1,77 @@
+// Copyright 2019 Open Networking Foundation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/Shopify/sarama"
+)
+
+// stalledProducer is a producer whose input buffer is never drained
+type stalledProducer struct {
+	sarama.AsyncProducer
+	input chan *sarama.ProducerMessage
+}
+
+func (p *stalledProducer) Input() chan<- *sarama.ProducerMessage {
+	return p.input
+}
+
+func TestDeadLetterDropsWhenProducerStalls(t *testing.T) {
+	q := newDeadLetterQueue(BrokerInfo{Name: "dead-letter-stalled", DeadLetterTopic: "dead"}, sarama.NewConfig())
+	q.producer = &stalledProducer{input: make(chan *sarama.ProducerMessage, 1)}
+	msg := &sarama.ConsumerMessage{Topic: "test", Value: []byte("{")}
+	forwarded := seriesOf(t, deadLetters, "dead-letter-stalled", "test")
+	dropped := seriesOf(t, deadLettersDropped, "dead-letter-stalled", "test")
+
+	// the second message doesn't fit in the buffer, it mustn't block
+	q.send(msg, "json", errors.New("invalid"))
+	q.send(msg, "json", errors.New("invalid"))
+
+	if n := seriesOf(t, deadLetters, "dead-letter-stalled", "test") - forwarded; n != 1 {
+		t.Errorf("expected 1 forwarded message, got %v", n)
+	}
+	if n := seriesOf(t, deadLettersDropped, "dead-letter-stalled", "test") - dropped; n != 1 {
+		t.Errorf("expected 1 dropped message, got %v", n)
+	}
+}
+
+func TestDeadLetterBacksOffProducerCreation(t *testing.T) {
+	config := sarama.NewConfig()
+	config.Metadata.Retry.Max = 0
+	q := newDeadLetterQueue(BrokerInfo{
+		Name:            "dead-letter-unreachable",
+		Hosts:           []string{"127.0.0.1:1"},
+		DeadLetterTopic: "dead",
+	}, config)
+	msg := &sarama.ConsumerMessage{Topic: "test", Value: []byte("{")}
+	dropped := seriesOf(t, deadLettersDropped, "dead-letter-unreachable", "test")
+
+	q.send(msg, "json", errors.New("invalid"))
+	if q.producer != nil || q.retry.current != initialReconnectBackoff {
+		t.Fatalf("expected a failed producer creation and a %s backoff, got %s", initialReconnectBackoff, q.retry.current)
+	}
+
+	// the producer isn't created again before the backoff elapses
+	q.send(msg, "json", errors.New("invalid"))
+	if q.retry.current != initialReconnectBackoff {
+		t.Errorf("expected no other producer creation, got a %s backoff", q.retry.current)
+	}
+	if n := seriesOf(t, deadLettersDropped, "dead-letter-unreachable", "test") - dropped; n != 2 {
+		t.Errorf("expected 2 dropped messages, got %v", n)
+	}
+}
@@ -0,0 +1,107 @@
+// Copyright 2019 Open Networking Foundation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+const (
+	reasonUnmarshal      = "unmarshal"
+	reasonInvalidKPI     = "invalid_kpi"
+	reasonUnknownDecoder = "unknown_decoder"
+)
+
+// Message is a kafka message to decode
+type Message struct {
+	Cluster   string
+	Topic     string
+	Timestamp time.Time
+	Value     []byte
+}
+
+// Decoder turns the messages of a topic into metrics
+type Decoder interface {
+	// Decode updates the metrics from a message, errors should be
+	// exportError so that they are reported with a reason
+	Decode(msg *Message) error
+}
+
+// DecoderFunc adapts a function to the Decoder interface
+type DecoderFunc func(msg *Message) error
+
+func (f DecoderFunc) Decode(msg *Message) error {
+	return f(msg)
+}
+
+// decoders available to the configuration, by name
+var decoders = make(map[string]Decoder)
+
+// decoders of the well known topics, used when a topic doesn't configure one
+var topicDecoders = map[string]string{
+	"voltha.kpis":         "voltha",
+	"onos.kpis":           "onos",
+	"importer.kpis":       "importer",
+	"onos.aaa.stats.kpis": "onosaaa",
+}
+
+// registerDecoder makes a decoder available to the topics configuration,
+// it is meant to be called from init()
+func registerDecoder(name string, decoder Decoder) {
+	if _, exists := decoders[name]; exists {
+		panic(fmt.Sprintf("decoder %s registered twice", name))
+	}
+	decoders[name] = decoder
+}
+
+// decoderNames returns the names of the registered decoders
+func decoderNames() []string {
+	names := make([]string, 0, len(decoders))
+	for name := range decoders {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// decoderFor returns the name of the decoder of a topic
+func decoderFor(topic TopicInfo) string {
+	if topic.Decoder != "" {
+		return topic.Decoder
+	}
+	return topicDecoders[topic.Name]
+}
+
+// exportError is a message that could not be exported, the reason is
+// used as label of the decode errors metric
+type exportError struct {
+	reason string
+	err    error
+}
+
+func (e *exportError) Error() string {
+	return e.reason + ": " + e.err.Error()
+}
+
+// export updates the metrics from a message using the named decoder
+func export(decoder string, msg *Message) error {
+	d, ok := decoders[decoder]
+	if !ok {
+		return &exportError{reason: reasonUnknownDecoder, err: fmt.Errorf("unknown decoder [%s]", decoder)}
+	}
+	return d.Decode(msg)
+}
@@ -0,0 +1,101 @@
+// Copyright 2019 Open Networking Foundation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"testing"
+)
+
+func TestExportInvalidVolthaKPI(t *testing.T) {
+	valid := `{"metrics": {"tx_bytes": 10}, "metadata": {"title": "Ethernet", "device_id": "test-invalid-kpi", "context": {}}}`
+	for _, invalid := range []string{
+		`null`,
+		`{"metrics": {"tx_bytes": 10}}`,
+		`{"metrics": {"tx_bytes": 10}, "metadata": {"title": "Ethernet", "device_id": "test-invalid-kpi"}}`,
+		`{"metadata": {"title": "Ethernet", "device_id": "test-invalid-kpi", "context": {}}}`,
+	} {
+		msg := &Message{
+			Cluster: "voltha",
+			Topic:   "voltha.kpis",
+			Value:   []byte(`{"type": "slice", "ts": 1, "slice_data": [` + valid + `, ` + invalid + `]}`),
+		}
+		if err := export("voltha", msg); errorReason(err) != reasonInvalidKPI {
+			t.Errorf("slice_data %s: error %v, want %s", invalid, err, reasonInvalidKPI)
+		}
+	}
+
+	// the valid slice is not exported either
+	for _, m := range gather(t, volthaTxBytesTotal) {
+		if labelValue(m, "device_id") == "test-invalid-kpi" {
+			t.Errorf("invalid KPI exported: %v", m)
+		}
+	}
+}
+
+func TestExportPonOptical(t *testing.T) {
+	msg := &Message{
+		Cluster: "voltha",
+		Topic:   "voltha.kpis",
+		Value: []byte(`{"type": "slice", "ts": 1, "slice_data": [
+			{
+				"metrics": {"transmit_power_dBm": 2.5, "receive_power_dBm": -20.5, "temperature": 41},
+				"metadata": {"title": "PON_Optical", "device_id": "test-optical-olt", "context": {"intf_id": "1", "pon_id": "1", "port_no": "536870913"}}
+			},
+			{
+				"metrics": {"transmit_power_dBm": 1.5, "receive_power_dBm": -22},
+				"metadata": {"title": "PON_Optical", "device_id": "test-optical-onu", "context": {"intf_id": "1"}}
+			}
+		]}`),
+	}
+	if err := export("voltha", msg); err != nil {
+		t.Fatal(err)
+	}
+
+	// each slice is exported in the families of its device only
+	for _, c := range []struct {
+		family *gaugeVec
+		device string
+		want   float64
+		port   string
+	}{
+		{volthaOltPonOpticalTxPowerDbm, "test-optical-olt", 2.5, "536870913"},
+		{volthaOltPonOpticalRxPowerDbm, "test-optical-olt", -20.5, "536870913"},
+		{volthaOltPonTransceiverTemperatureCelsius, "test-optical-olt", 41, "536870913"},
+		{volthaOnuOpticalTxPowerDbm, "test-optical-onu", 1.5, ""},
+		{volthaOnuOpticalRxPowerDbm, "test-optical-onu", -22, ""},
+	} {
+		var found []float64
+		for _, m := range gather(t, c.family) {
+			device := labelValue(m, "device_id")
+			if device != "test-optical-olt" && device != "test-optical-onu" {
+				continue
+			}
+			if device != c.device || labelValue(m, "pon_id") != "1" || labelValue(m, "port_number") != c.port {
+				t.Errorf("%s: unexpected series %v", c.device, m.Label)
+			}
+			found = append(found, m.GetGauge().GetValue())
+		}
+		if len(found) != 1 || found[0] != c.want {
+			t.Errorf("%s: gathered %v, want %v", c.device, found, c.want)
+		}
+	}
+
+	// missing levels are not exported
+	for _, m := range gather(t, volthaOltPonLaserBiasCurrentMilliamperes) {
+		if labelValue(m, "device_id") == "test-optical-olt" {
+			t.Errorf("missing level exported: %v", m)
+		}
+	}
+}
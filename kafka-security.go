@@ -0,0 +1,116 @@
+// Copyright 2019 Open Networking Foundation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/Shopify/sarama"
+	"github.com/xdg/scram"
+)
+
+// configureSecurity enables TLS and SASL on the sarama config
+func configureSecurity(config *sarama.Config, tlsInfo TLSInfo, saslInfo SASLInfo) error {
+	if tlsInfo.Enabled {
+		tlsConfig, err := newTLSConfig(tlsInfo)
+		if err != nil {
+			return err
+		}
+		config.Net.TLS.Enable = true
+		config.Net.TLS.Config = tlsConfig
+	}
+
+	if saslInfo.Enabled {
+		config.Net.SASL.Enable = true
+		config.Net.SASL.User = saslInfo.Username
+		config.Net.SASL.Password = saslInfo.Password
+
+		switch strings.ToUpper(saslInfo.Mechanism) {
+		case "", sarama.SASLTypePlaintext:
+			config.Net.SASL.Mechanism = sarama.SASLTypePlaintext
+		case sarama.SASLTypeSCRAMSHA256:
+			config.Net.SASL.Mechanism = sarama.SASLTypeSCRAMSHA256
+			config.Net.SASL.SCRAMClientGeneratorFunc = func() sarama.SCRAMClient {
+				return &scramClient{HashGeneratorFcn: sha256.New}
+			}
+		case sarama.SASLTypeSCRAMSHA512:
+			config.Net.SASL.Mechanism = sarama.SASLTypeSCRAMSHA512
+			config.Net.SASL.SCRAMClientGeneratorFunc = func() sarama.SCRAMClient {
+				return &scramClient{HashGeneratorFcn: sha512.New}
+			}
+		default:
+			return fmt.Errorf("unsupported SASL mechanism %s", saslInfo.Mechanism)
+		}
+	}
+	return nil
+}
+
+func newTLSConfig(info TLSInfo) (*tls.Config, error) {
+	config := &tls.Config{
+		InsecureSkipVerify: info.InsecureSkipVerify,
+	}
+
+	if info.CAFile != "" {
+		ca, err := ioutil.ReadFile(info.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("no certificate found in %s", info.CAFile)
+		}
+	}
+
+	if info.CertFile != "" || info.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(info.CertFile, info.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return config, nil
+}
+
+// scramClient adapts the SCRAM client of github.com/xdg/scram to sarama,
+// which only implements PLAIN itself. User names and passwords are
+// normalized with SASLprep.
+type scramClient struct {
+	*scram.Client
+	*scram.ClientConversation
+	scram.HashGeneratorFcn
+}
+
+func (c *scramClient) Begin(username, password, authzID string) (err error) {
+	c.Client, err = c.HashGeneratorFcn.NewClient(username, password, authzID)
+	if err != nil {
+		return err
+	}
+	c.ClientConversation = c.Client.NewConversation()
+	return nil
+}
+
+func (c *scramClient) Step(challenge string) (string, error) {
+	return c.ClientConversation.Step(challenge)
+}
+
+func (c *scramClient) Done() bool {
+	return c.ClientConversation.Done()
+}
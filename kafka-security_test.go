@@ -0,0 +1,84 @@
+// Copyright 2019 Open Networking Foundation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"crypto/sha256"
+	"testing"
+)
+
+// the SCRAM-SHA-256 exchange of RFC 7677, section 3
+const (
+	rfc7677ClientNonce = "rOprNGfwEbeRWgbNEkqO"
+	rfc7677ClientFirst = "n,,n=user,r=rOprNGfwEbeRWgbNEkqO"
+	rfc7677ServerFirst = "r=rOprNGfwEbeRWgbNEkqO%hvYDpWUa2RaTCAfuxFIlj)hNlF$k0,s=W22ZaJ0SNY7soEsUEjb6gQ==,i=4096"
+	rfc7677ClientFinal = "c=biws,r=rOprNGfwEbeRWgbNEkqO%hvYDpWUa2RaTCAfuxFIlj)hNlF$k0,p=dHzbZapWIk4jUhN+Ute9ytag9zjfMHgsqmmiz7AndVQ="
+	rfc7677ServerFinal = "v=6rriTRBi23WpRR/wtup+mMhUZUn/dB5nLTJRsjl95G4="
+)
+
+func newRFC7677Client(t *testing.T) *scramClient {
+	c := &scramClient{HashGeneratorFcn: sha256.New}
+	if err := c.Begin("user", "pencil", ""); err != nil {
+		t.Fatal(err)
+	}
+	// the RFC exchange uses a fixed client nonce
+	c.ClientConversation = c.Client.WithNonceGenerator(func() string { return rfc7677ClientNonce }).NewConversation()
+	return c
+}
+
+func TestScramSHA256(t *testing.T) {
+	c := newRFC7677Client(t)
+
+	for _, step := range []struct{ challenge, response string }{
+		{"", rfc7677ClientFirst},
+		{rfc7677ServerFirst, rfc7677ClientFinal},
+		{rfc7677ServerFinal, ""},
+	} {
+		response, err := c.Step(step.challenge)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if response != step.response {
+			t.Errorf("response to [%s] = %s, want %s", step.challenge, response, step.response)
+		}
+	}
+	if !c.Done() {
+		t.Error("exchange not done")
+	}
+}
+
+func TestScramServerSignatureMismatch(t *testing.T) {
+	c := newRFC7677Client(t)
+	c.Step("")
+	c.Step(rfc7677ServerFirst)
+	if _, err := c.Step("v=AAAATRBi23WpRR/wtup+mMhUZUn/dB5nLTJRsjl95G4="); err == nil {
+		t.Error("server signature mismatch not detected")
+	}
+}
+
+func TestInvalidBrokerSettings(t *testing.T) {
+	for _, broker := range []BrokerInfo{
+		{Version: "not-a-version"},
+		{SASL: SASLInfo{Enabled: true, Mechanism: "GSSAPI"}},
+		{TLS: TLSInfo{Enabled: true, CAFile: "/nonexistent/ca.crt"}},
+	} {
+		if _, err := newKafkaConfig(broker); err == nil {
+			t.Errorf("settings %+v accepted", broker)
+		}
+	}
+	if _, err := newKafkaConfig(BrokerInfo{}); err != nil {
+		t.Errorf("default settings rejected: %s", err)
+	}
+}
@@ -0,0 +1,119 @@
+// Copyright 2019 Open Networking Foundation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// exportTimestamps exports the samples with the timestamp of their KPI
+// instead of the scrape time, set from the target configuration
+var exportTimestamps bool
+
+// kpiTime converts a KPI timestamp, in seconds since the epoch, the zero time
+// if the KPI has none
+func kpiTime(ts float64) time.Time {
+	if ts <= 0 {
+		return time.Time{}
+	}
+	sec, frac := math.Modf(ts)
+	return time.Unix(int64(sec), int64(frac*1e9))
+}
+
+// sampleTimes keeps the KPI timestamp of the series of a metric family
+type sampleTimes struct {
+	// positions of the label values once sorted by label name, the order
+	// of the labels of the collected metrics
+	order []int
+
+	mu sync.Mutex
+	at map[string]time.Time
+}
+
+func newSampleTimes(labels []string) *sampleTimes {
+	order := make([]int, len(labels))
+	for i := range order {
+		order[i] = i
+	}
+	sort.Slice(order, func(i, j int) bool { return labels[order[i]] < labels[order[j]] })
+	return &sampleTimes{order: order, at: make(map[string]time.Time)}
+}
+
+func (s *sampleTimes) key(labels []string) string {
+	sorted := make([]string, len(s.order))
+	for i, pos := range s.order {
+		if pos < len(labels) {
+			sorted[i] = labels[pos]
+		}
+	}
+	return strings.Join(sorted, "\xff")
+}
+
+// stamp records the KPI timestamp of a series
+func (s *sampleTimes) stamp(ts time.Time, labels []string) {
+	if !exportTimestamps || ts.IsZero() {
+		return
+	}
+	key := s.key(labels)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.at[key] = ts
+}
+
+// forget drops the timestamp of a deleted series
+func (s *sampleTimes) forget(labels []string) {
+	key := s.key(labels)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.at, key)
+}
+
+// collect collects the metrics of the family, with the timestamp of their
+// KPI when exporting timestamps
+func (s *sampleTimes) collect(family prometheus.Collector, ch chan<- prometheus.Metric) {
+	if !exportTimestamps {
+		family.Collect(ch)
+		return
+	}
+
+	metrics := make(chan prometheus.Metric)
+	go func() {
+		family.Collect(metrics)
+		close(metrics)
+	}()
+	for m := range metrics {
+		pb := &dto.Metric{}
+		if err := m.Write(pb); err == nil {
+			values := make([]string, len(pb.Label))
+			for i, label := range pb.Label {
+				values[i] = label.GetValue()
+			}
+			s.mu.Lock()
+			ts, ok := s.at[strings.Join(values, "\xff")]
+			s.mu.Unlock()
+			if ok {
+				m = prometheus.NewMetricWithTimestamp(ts, m)
+			}
+		}
+		ch <- m
+	}
+}
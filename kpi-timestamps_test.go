@@ -0,0 +1,75 @@
+// Copyright 2019 Open Networking Foundation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestStampedGauge(t *testing.T) {
+	exportTimestamps = true
+	defer func() { exportTimestamps = false }()
+	defer removeTracker("test_stamped")
+	defer removeTracker("test_stamped_relabeled")
+
+	// the label names are not sorted, the metrics are collected with
+	// sorted labels
+	v := newGaugeVec(prometheus.GaugeOpts{Name: "test_stamped", Help: "test"}, []string{"device_id", "cluster"})
+
+	replacement := "pod1"
+	rule, err := newRelabelRule(RelabelInfo{TargetLabel: "a_site", Replacement: &replacement})
+	if err != nil {
+		t.Fatal(err)
+	}
+	relabeled := newGaugeVec(prometheus.GaugeOpts{Name: "test_stamped_relabeled", Help: "test"}, []string{"cluster", "device_id"})
+	r, err := newRelabeling("test_stamped_relabeled", []string{"cluster", "device_id"}, []*relabelRule{rule})
+	if err != nil {
+		t.Fatal(err)
+	}
+	relabeled.relabel(r)
+
+	at := kpiTime(1536617075.5)
+	for _, f := range []*gaugeVec{v, relabeled} {
+		labels := []string{"olt-1", "voltha"}
+		if f == relabeled {
+			labels = []string{"voltha", "olt-1"}
+		}
+		f.at(at).WithLabelValues(labels...).Set(1)
+		// not stamped
+		f.WithLabelValues(labels[0]+"-unstamped", labels[1]+"-unstamped").Set(2)
+
+		metrics := gather(t, f)
+		if len(metrics) != 2 {
+			t.Fatalf("%d series gathered, want 2", len(metrics))
+		}
+		for _, m := range metrics {
+			var want int64
+			if m.GetGauge().GetValue() == 1 {
+				want = 1536617075500
+			}
+			if got := m.GetTimestampMs(); got != want {
+				t.Errorf("series %v stamped at %d, want %d", m.Label, got, want)
+			}
+		}
+	}
+
+	for _, m := range gather(t, relabeled) {
+		if labelValue(m, "a_site") != "pod1" {
+			t.Errorf("series %v not relabeled", m.Label)
+		}
+	}
+}
@@ -15,6 +15,8 @@
 package main
 
 import (
+	"context"
+	"fmt"
 	"gerrit.opencord.org/kafka-topic-exporter/common/logger"
 	"github.com/Shopify/sarama"
 	"github.com/prometheus/client_golang/prometheus"
@@ -22,62 +24,175 @@ import (
 	"io/ioutil"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
+	"regexp"
 	"strconv"
 	"strings"
 	"sync"
+	"syscall"
 )
 
-func kafkaInit(broker BrokerInfo) {
+const (
+	defaultConsumerGroup = "kafka-topic-exporter"
+	defaultKafkaVersion  = "1.0.0"
+)
+
+// newKafkaConfig returns the sarama config of a broker, or an error if its
+// settings are invalid
+func newKafkaConfig(broker BrokerInfo) (*sarama.Config, error) {
 	config := sarama.NewConfig()
 	config.Consumer.Return.Errors = true
-	var wg sync.WaitGroup
-
-	master, err := sarama.NewConsumer([]string{broker.Host}, config)
+	config.Consumer.Offsets.Initial = sarama.OffsetOldest
 
+	// consumer groups need at least kafka 0.10.2, sarama defaults to 0.8.2
+	if broker.Version == "" {
+		broker.Version = defaultKafkaVersion
+	}
+	version, err := sarama.ParseKafkaVersion(broker.Version)
 	if err != nil {
-		logger.Panic("kafkaInit panic")
-		panic(err)
+		return nil, fmt.Errorf("invalid kafka version [%s]", broker.Version)
 	}
-	defer func() {
-		logger.Debug("kafkaInit close connection")
-		if err := master.Close(); err != nil {
-			panic(err)
-		}
-	}()
+	config.Version = version
+
+	if err := configureSecurity(config, broker.TLS, broker.SASL); err != nil {
+		return nil, fmt.Errorf("invalid security settings: %s", err)
+	}
+	return config, nil
+}
 
-	// read topics from config
-	topics := broker.Topics
+func kafkaInit(ctx context.Context, broker BrokerInfo, config *sarama.Config) {
+	var wg sync.WaitGroup
 
-	// we are spinning threads for each topic, we need to wait for
-	// them to exit before stopping the kafka connection
-	wg.Add(len(topics))
+	if broker.Group == "" {
+		logger.Warn("Consumer group not configured, using default %s", defaultConsumerGroup)
+		broker.Group = defaultConsumerGroup
+	}
 
+	// read topics from config, topics given by name are consumed right
+	// away, topic patterns once matching topics are discovered
+	var topics []TopicInfo
+	var patterns []topicPattern
+	static := make(map[string]bool)
+	for _, topic := range broker.Topics {
+		if topic.Name != "" {
+			topics = append(topics, topic)
+			static[topic.Name] = true
+			continue
+		}
+		re, err := regexp.Compile(topic.Pattern)
+		if err != nil || topic.Pattern == "" {
+			logger.Error("Invalid topic pattern [%s] for [%s], skipping it", topic.Pattern, broker.Name)
+			continue
+		}
+		patterns = append(patterns, topicPattern{info: topic, regexp: re})
+	}
+
+	deadLetters := newDeadLetterQueue(broker, config)
+	defer deadLetters.close()
+
+	// a single member of the group consumes all the topics of the cluster,
+	// discovered topics are added to its subscription
+	consumer := newGroupConsumer(broker, config, deadLetters)
 	for _, topic := range topics {
-		t := topic
-		go topicListener(&t, master, wg)
+		consumer.addTopic(topic)
+	}
+
+	// we need to wait for the consumer to leave the consumer group before
+	// exiting
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		consumer.run(ctx)
+	}()
+
+	if len(patterns) > 0 {
+		wg.Add(1)
+		go newTopicDiscovery(broker, config, consumer, patterns, static).run(ctx, &wg)
 	}
 
 	wg.Wait()
 }
 
-func runServer(target TargetInfo) {
+// runServer serves the metrics and probes until the HTTP server fails
+func runServer(target TargetInfo) error {
 	if target.Port == 0 {
 		logger.Warn("Prometheus target port not configured, using default 8080")
 		target.Port = 8080
 	}
 	logger.Debug("Starting HTTP Server on %d port", target.Port)
 	http.Handle("/metrics", prometheus.Handler())
-	http.ListenAndServe(":"+strconv.Itoa(target.Port), nil)
+	http.HandleFunc("/health", healthHandler)
+	http.HandleFunc("/ready", readyHandler)
+	return http.ListenAndServe(":"+strconv.Itoa(target.Port), nil)
 }
 
-func init() {
-	// register metrics within Prometheus
+// registerMetrics registers the metrics within Prometheus, once they are
+// relabeled
+func registerMetrics() {
+	prometheus.MustRegister(brokerUp)
+	prometheus.MustRegister(consumerReconnects)
+	prometheus.MustRegister(decodeErrors)
+	prometheus.MustRegister(deadLetters)
+	prometheus.MustRegister(deadLettersDropped)
+	prometheus.MustRegister(seriesExpired)
+	prometheus.MustRegister(seriesCount)
+	prometheus.MustRegister(seriesLimited)
+	prometheus.MustRegister(messagesConsumed)
+	prometheus.MustRegister(bytesConsumed)
+	prometheus.MustRegister(exportDuration)
+	prometheus.MustRegister(lastMessageTimestamp)
+	prometheus.MustRegister(partitionOffset)
+	prometheus.MustRegister(partitionHighWaterMark)
+	prometheus.MustRegister(consumerLag)
+
 	prometheus.MustRegister(volthaTxBytesTotal)
 	prometheus.MustRegister(volthaRxBytesTotal)
 	prometheus.MustRegister(volthaTxPacketsTotal)
 	prometheus.MustRegister(volthaRxPacketsTotal)
 	prometheus.MustRegister(volthaTxErrorPacketsTotal)
 	prometheus.MustRegister(volthaRxErrorPacketsTotal)
+	prometheus.MustRegister(volthaTxBcastPacketsTotal)
+	prometheus.MustRegister(volthaTxUcastPacketsTotal)
+	prometheus.MustRegister(volthaTxMcastPacketsTotal)
+	prometheus.MustRegister(volthaRxBcastPacketsTotal)
+	prometheus.MustRegister(volthaRxUcastPacketsTotal)
+	prometheus.MustRegister(volthaRxMcastPacketsTotal)
+	prometheus.MustRegister(volthaOnuOpticalTxPowerDbm)
+	prometheus.MustRegister(volthaOnuOpticalRxPowerDbm)
+	prometheus.MustRegister(volthaOnuLaserBiasCurrentMilliamperes)
+	prometheus.MustRegister(volthaOnuTransceiverVoltageVolts)
+	prometheus.MustRegister(volthaOnuTransceiverTemperatureCelsius)
+	prometheus.MustRegister(volthaOltPonOpticalTxPowerDbm)
+	prometheus.MustRegister(volthaOltPonOpticalRxPowerDbm)
+	prometheus.MustRegister(volthaOltPonLaserBiasCurrentMilliamperes)
+	prometheus.MustRegister(volthaOltPonTransceiverVoltageVolts)
+	prometheus.MustRegister(volthaOltPonTransceiverTemperatureCelsius)
+	prometheus.MustRegister(volthaLastKpiTimestamp)
+	prometheus.MustRegister(volthaKpiTimestampSkew)
+
+	prometheus.MustRegister(volthaOnuBridgePortPacketsTotal)
+	prometheus.MustRegister(volthaOnuBridgePortBytesTotal)
+	prometheus.MustRegister(volthaOnuFecCorrectedBytesTotal)
+	prometheus.MustRegister(volthaOnuFecCorrectedCodeWordsTotal)
+	prometheus.MustRegister(volthaOnuFecUncorrectableCodeWordsTotal)
+	prometheus.MustRegister(volthaOnuFecCodeWordsTotal)
+	prometheus.MustRegister(volthaOnuFecSecondsTotal)
+	prometheus.MustRegister(volthaOnuUniFcsErrorsTotal)
+	prometheus.MustRegister(volthaOnuUniExcessiveCollisionsTotal)
+	prometheus.MustRegister(volthaOnuUniLateCollisionsTotal)
+	prometheus.MustRegister(volthaOnuUniFramesTooLongTotal)
+	prometheus.MustRegister(volthaOnuUniRxBufferOverflowsTotal)
+	prometheus.MustRegister(volthaOnuUniTxBufferOverflowsTotal)
+	prometheus.MustRegister(volthaOnuUniSingleCollisionFramesTotal)
+	prometheus.MustRegister(volthaOnuUniMultipleCollisionFramesTotal)
+	prometheus.MustRegister(volthaOnuUniSqeErrorsTotal)
+	prometheus.MustRegister(volthaOnuUniDeferredTxTotal)
+	prometheus.MustRegister(volthaOnuUniInternalMacTxErrorsTotal)
+	prometheus.MustRegister(volthaOnuUniCarrierSenseErrorsTotal)
+	prometheus.MustRegister(volthaOnuUniAlignmentErrorsTotal)
+	prometheus.MustRegister(volthaOnuUniInternalMacRxErrorsTotal)
+	prometheus.MustRegister(volthaOnuPmIntervalResets)
 
 	prometheus.MustRegister(onosTxBytesTotal)
 	prometheus.MustRegister(onosRxBytesTotal)
@@ -86,6 +201,16 @@ func init() {
 	prometheus.MustRegister(onosTxDropPacketsTotal)
 	prometheus.MustRegister(onosRxDropPacketsTotal)
 
+	prometheus.MustRegister(importerTemperatureCelsius)
+	prometheus.MustRegister(importerFanSpeed)
+	prometheus.MustRegister(importerPowerSupplyInputWatts)
+	prometheus.MustRegister(importerPowerSupplyOutputWatts)
+	prometheus.MustRegister(importerPowerSupplyInputVolts)
+	prometheus.MustRegister(importerPowerSupplyHealthy)
+	prometheus.MustRegister(importerCPUUtilizationPercent)
+	prometheus.MustRegister(importerMemoryUsedBytes)
+	prometheus.MustRegister(importerMemoryTotalBytes)
+
 	prometheus.MustRegister(onosaaaRxAcceptResponses)
 	prometheus.MustRegister(onosaaaRxRejectResponses)
 	prometheus.MustRegister(onosaaaRxChallengeResponses)
@@ -98,6 +223,14 @@ func init() {
 	prometheus.MustRegister(onosaaaRxUnknownserver)
 	prometheus.MustRegister(onosaaaRequestRttMillis)
 	prometheus.MustRegister(onosaaaRequestReTx)
+	prometheus.MustRegister(onosaaaPortEapolLogoffRx)
+	prometheus.MustRegister(onosaaaPortEapolAuthSuccessTrans)
+	prometheus.MustRegister(onosaaaPortEapolAuthFailureTrans)
+	prometheus.MustRegister(onosaaaPortEapolStartReqTrans)
+	prometheus.MustRegister(onosaaaPortEapolFramesTx)
+	prometheus.MustRegister(onosaaaPortValidEapolFramesRx)
+	prometheus.MustRegister(onosaaaPortPendingSupplicantResponses)
+	prometheus.MustRegister(onosaaaPortAuthStateIdle)
 }
 
 func loadConfigFile() Config {
@@ -119,9 +252,91 @@ func main() {
 	conf := loadConfigFile()
 
 	// logger setup
-	logger.Setup(conf.Logger.Host, strings.ToUpper(conf.Logger.LogLevel))
-	logger.Info("Connecting to broker: [%s]", conf.Broker.Host)
+	loggerConfig := sarama.NewConfig()
+	if err := configureSecurity(loggerConfig, conf.Logger.TLS, conf.Logger.SASL); err != nil {
+		log.Fatalf("Logger security settings: %v", err)
+	}
+	logger.SetupWithKafkaConfig(conf.Logger.Host, loggerConfig, strings.ToUpper(conf.Logger.LogLevel))
+
+	// relabel the families before they are registered and any series is
+	// created
+	configureRelabel(conf.RelabelConfigs)
+	registerMetrics()
+	// decoders declared in the configuration
+	registerMappings(conf.Mappings)
+	configureRtt(conf.AAARtt)
+	configureExpiry(conf.Expiry)
+	configureLimits(conf.Limits)
+	exportTimestamps = conf.Target.Timestamps
+
+	if conf.State.File != "" {
+		loadPMHistory(conf.State.File)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		logger.Warn("Interrupt is detected")
+		cancel()
+	}()
+
+	// every broker is a separate kafka cluster, the broker name is used
+	// as the cluster label of its metrics
+	var wg sync.WaitGroup
+	names := make(map[string]bool)
+	for _, broker := range conf.brokers() {
+		hosts := broker.bootstrapHosts()
+		if len(hosts) == 0 {
+			logger.Error("Broker [%s] has no host configured, skipping it", broker.Name)
+			continue
+		}
+		if broker.Name == "" {
+			broker.Name = hosts[0]
+		}
+		if names[broker.Name] {
+			logger.Error("Broker name [%s] is not unique, skipping it", broker.Name)
+			continue
+		}
+		names[broker.Name] = true
+		config, err := newKafkaConfig(broker)
+		if err != nil {
+			logger.Error("Broker [%s] settings are invalid, skipping it: %s", broker.Name, err)
+			continue
+		}
+
+		logger.Info("Connecting to broker [%s]: %v", broker.Name, hosts)
+		wg.Add(1)
+		go func(broker BrokerInfo) {
+			defer wg.Done()
+			kafkaInit(ctx, broker, config)
+		}(broker)
+	}
 
-	go kafkaInit(conf.Broker)
-	runServer(conf.Target)
-}
\ No newline at end of file
+	if conf.State.File != "" {
+		go runPMHistoryState(ctx, conf.State)
+	}
+	go runExpiry(ctx, conf.Expiry)
+	// without the HTTP server the exporter is useless, and cannot even be
+	// probed, so stop it
+	serverFailed := make(chan error, 1)
+	go func() {
+		err := runServer(conf.Target)
+		logger.Error("HTTP server failed: %s", err)
+		serverFailed <- err
+		cancel()
+	}()
+
+	// save the PM history once no more message is consumed
+	<-ctx.Done()
+	wg.Wait()
+	if conf.State.File != "" {
+		savePMHistory(conf.State.File)
+	}
+	select {
+	case <-serverFailed:
+		os.Exit(1)
+	default:
+	}
+}
@@ -0,0 +1,58 @@
+// Copyright 2019 Open Networking Foundation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"os"
+	"testing"
+
+	"gerrit.opencord.org/kafka-topic-exporter/common/logger"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func TestMain(m *testing.M) {
+	// no kafka broker, the logs go to stderr
+	logger.Setup("", "ERROR")
+	os.Exit(m.Run())
+}
+
+// gather returns the series of a metric family, as collected by a pedantic
+// registry
+func gather(t *testing.T, family prometheus.Collector) []*dto.Metric {
+	t.Helper()
+	registry := prometheus.NewPedanticRegistry()
+	if err := registry.Register(family); err != nil {
+		t.Fatal(err)
+	}
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(families) == 0 {
+		return nil
+	}
+	return families[0].Metric
+}
+
+// labelValue returns the value of a label of a series, empty if missing
+func labelValue(m *dto.Metric, name string) string {
+	for _, label := range m.Label {
+		if label.GetName() == name {
+			return label.GetValue()
+		}
+	}
+	return ""
+}
@@ -0,0 +1,348 @@
+// Copyright 2019 Open Networking Foundation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"gerrit.opencord.org/kafka-topic-exporter/common/logger"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// jsonPath is a small subset of JSONPath: `$` is the message root, `.name`
+// selects a field, `[n]` an array element and `[*]` all the elements of an
+// array or all the values of an object. Paths not starting with `$` are
+// relative to the current record.
+type jsonPath struct {
+	root  bool
+	steps []pathStep
+}
+
+type pathStep struct {
+	field string
+	// index of the array element, -1 for all elements, when field is empty
+	index int
+}
+
+func parseJSONPath(path string) (jsonPath, error) {
+	p := jsonPath{}
+	s := path
+	if strings.HasPrefix(s, "$") {
+		p.root = true
+		s = s[1:]
+	}
+	for s != "" {
+		if s[0] == '[' {
+			end := strings.IndexByte(s, ']')
+			if end < 0 {
+				return p, fmt.Errorf("missing ] in %s", path)
+			}
+			index := -1
+			if s[1:end] != "*" {
+				var err error
+				if index, err = strconv.Atoi(s[1:end]); err != nil || index < 0 {
+					return p, fmt.Errorf("invalid index %s in %s", s[1:end], path)
+				}
+			}
+			p.steps = append(p.steps, pathStep{index: index})
+			s = s[end+1:]
+			continue
+		}
+		if s[0] == '.' {
+			s = s[1:]
+		}
+		end := strings.IndexAny(s, ".[")
+		if end < 0 {
+			end = len(s)
+		}
+		if end == 0 {
+			return p, fmt.Errorf("empty field name in %s", path)
+		}
+		p.steps = append(p.steps, pathStep{field: s[:end]})
+		s = s[end:]
+	}
+	return p, nil
+}
+
+// eval returns all the nodes matching the path
+func (p jsonPath) eval(root interface{}, current interface{}) []interface{} {
+	nodes := []interface{}{current}
+	if p.root {
+		nodes = []interface{}{root}
+	}
+	for _, step := range p.steps {
+		var next []interface{}
+		for _, node := range nodes {
+			switch n := node.(type) {
+			case map[string]interface{}:
+				if step.field != "" {
+					if v, ok := n[step.field]; ok {
+						next = append(next, v)
+					}
+				} else if step.index < 0 {
+					for _, v := range n {
+						next = append(next, v)
+					}
+				}
+			case []interface{}:
+				if step.field != "" {
+					continue
+				}
+				if step.index < 0 {
+					next = append(next, n...)
+				} else if step.index < len(n) {
+					next = append(next, n[step.index])
+				}
+			}
+		}
+		nodes = next
+	}
+	return nodes
+}
+
+// first returns the first node matching the path
+func (p jsonPath) first(root interface{}, current interface{}) (interface{}, bool) {
+	nodes := p.eval(root, current)
+	if len(nodes) == 0 {
+		return nil, false
+	}
+	return nodes[0], true
+}
+
+func jsonLabel(node interface{}) string {
+	switch v := node.(type) {
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(v)
+	}
+	return ""
+}
+
+func jsonValue(node interface{}) (float64, bool) {
+	switch v := node.(type) {
+	case float64:
+		return v, true
+	case string:
+		f, err := strconv.ParseFloat(v, 64)
+		return f, err == nil
+	case bool:
+		if v {
+			return 1, true
+		}
+		return 0, true
+	}
+	return 0, false
+}
+
+// mappedMetric is a metric family declared in the configuration, each of its
+// series keeps the last value extracted from the messages
+type mappedMetric struct {
+	name      string
+	help      string
+	desc      *prometheus.Desc
+	valueType prometheus.ValueType
+	records   jsonPath
+	// label names are sorted, the cluster label comes first
+	labels     []string
+	labelPaths []jsonPath
+	value      jsonPath
+
+	tracker    *seriesTracker
+	relabeling *relabeling
+
+	mu     sync.Mutex
+	series map[string]*mappedSeries
+}
+
+type mappedSeries struct {
+	labelValues []string
+	value       float64
+}
+
+func newMappedMetric(info MetricMappingInfo) (*mappedMetric, error) {
+	m := &mappedMetric{name: info.Name, series: make(map[string]*mappedSeries)}
+
+	switch info.Type {
+	case "", "gauge":
+		m.valueType = prometheus.GaugeValue
+	case "counter":
+		m.valueType = prometheus.CounterValue
+	case "untyped":
+		m.valueType = prometheus.UntypedValue
+	default:
+		return nil, fmt.Errorf("unknown metric type %s", info.Type)
+	}
+
+	var err error
+	if info.Records == "" {
+		info.Records = "$"
+	}
+	if m.records, err = parseJSONPath(info.Records); err != nil {
+		return nil, err
+	}
+	if info.Value == "" {
+		return nil, fmt.Errorf("no value for metric %s", info.Name)
+	}
+	if m.value, err = parseJSONPath(info.Value); err != nil {
+		return nil, err
+	}
+
+	for label := range info.Labels {
+		m.labels = append(m.labels, label)
+	}
+	sort.Strings(m.labels)
+	for _, label := range m.labels {
+		path, err := parseJSONPath(info.Labels[label])
+		if err != nil {
+			return nil, err
+		}
+		m.labelPaths = append(m.labelPaths, path)
+	}
+
+	m.help = info.Help
+	if m.help == "" {
+		m.help = fmt.Sprintf("%s extracted from %s", info.Value, info.Records)
+	}
+	m.desc = prometheus.NewDesc(m.name, m.help, m.labelNames(), nil)
+	return m, nil
+}
+
+// labelNames returns the label names as declared, the cluster label first
+func (m *mappedMetric) labelNames() []string {
+	return append([]string{"cluster"}, m.labels...)
+}
+
+func (m *mappedMetric) relabel(r *relabeling) {
+	if r == nil {
+		return
+	}
+	m.relabeling = r
+	m.desc = prometheus.NewDesc(m.name, m.help, r.labels(nil), nil)
+}
+
+// update sets the series of every record of the message
+func (m *mappedMetric) update(cluster string, doc interface{}) {
+	for _, record := range m.records.eval(doc, doc) {
+		node, ok := m.value.first(doc, record)
+		if !ok {
+			continue
+		}
+		value, ok := jsonValue(node)
+		if !ok {
+			logger.Debug("Skipping non numeric value %v of %s", node, m.desc)
+			continue
+		}
+
+		labelValues := make([]string, 0, len(m.labels)+1)
+		labelValues = append(labelValues, cluster)
+		for _, path := range m.labelPaths {
+			node, _ := path.first(doc, record)
+			labelValues = append(labelValues, jsonLabel(node))
+		}
+		labelValues, keep := m.relabeling.apply(labelValues)
+		if keep {
+			labelValues, keep = m.tracker.admit(labelValues)
+		}
+		if !keep {
+			continue
+		}
+
+		key := strings.Join(labelValues, "\xff")
+		m.mu.Lock()
+		if s, ok := m.series[key]; ok {
+			s.value = value
+		} else {
+			m.series[key] = &mappedSeries{labelValues: labelValues, value: value}
+		}
+		m.mu.Unlock()
+	}
+}
+
+// delete removes an expired series
+func (m *mappedMetric) delete(labelValues []string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.series, strings.Join(labelValues, "\xff"))
+}
+
+func (m *mappedMetric) Describe(ch chan<- *prometheus.Desc) {
+	ch <- m.desc
+}
+
+func (m *mappedMetric) Collect(ch chan<- prometheus.Metric) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, s := range m.series {
+		ch <- prometheus.MustNewConstMetric(m.desc, m.valueType, s.value, s.labelValues...)
+	}
+}
+
+// mappingDecoder decodes JSON messages into the metrics of a mapping
+type mappingDecoder struct {
+	metrics []*mappedMetric
+}
+
+func (d *mappingDecoder) Decode(msg *Message) error {
+	var doc interface{}
+	if err := json.Unmarshal(msg.Value, &doc); err != nil {
+		return &exportError{reason: reasonUnmarshal, err: err}
+	}
+	for _, m := range d.metrics {
+		m.update(msg.Cluster, doc)
+	}
+	return nil
+}
+
+// registerMappings registers the metrics of the mappings within Prometheus
+// and each mapping as a decoder, invalid mappings are skipped
+func registerMappings(mappings []MappingInfo) {
+	for _, mapping := range mappings {
+		if _, exists := decoders[mapping.Decoder]; exists || mapping.Decoder == "" {
+			logger.Error("Mapping decoder name [%s] is empty or already used, skipping it", mapping.Decoder)
+			continue
+		}
+
+		decoder := &mappingDecoder{}
+		for _, info := range mapping.Metrics {
+			m, err := newMappedMetric(info)
+			if err == nil {
+				// relabeled first, so that it is registered with the
+				// labels it is collected with
+				m.relabel(configuredRelabeling(m.name, m.labelNames()))
+				err = prometheus.Register(m)
+			}
+			if err != nil {
+				logger.Error("Invalid metric [%s] of mapping [%s], skipping it: %s", info.Name, mapping.Decoder, err)
+				continue
+			}
+			// only the registered metric is tracked, a metric failing to
+			// register must not take the place of the one of the same name
+			trackRelabelable(m.name, m.labelNames(), m)
+			m.tracker = newSeriesTracker(info.Name, m.delete)
+			decoder.metrics = append(decoder.metrics, m)
+		}
+		registerDecoder(mapping.Decoder, decoder)
+		logger.Info("Registered mapping decoder [%s] with %d metrics", mapping.Decoder, len(decoder.metrics))
+	}
+}
@@ -0,0 +1,176 @@
+// Copyright 2019 Open Networking Foundation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestParseJSONPath(t *testing.T) {
+	for _, c := range []struct {
+		path string
+		want jsonPath
+	}{
+		{"$", jsonPath{root: true}},
+		{"$.ports[*].id", jsonPath{root: true, steps: []pathStep{{field: "ports"}, {index: -1}, {field: "id"}}}},
+		{"stats[2].rx", jsonPath{steps: []pathStep{{field: "stats"}, {index: 2}, {field: "rx"}}}},
+		{"[0][*]", jsonPath{steps: []pathStep{{index: 0}, {index: -1}}}},
+	} {
+		got, err := parseJSONPath(c.path)
+		if err != nil || !reflect.DeepEqual(got, c.want) {
+			t.Errorf("parseJSONPath(%s) = %+v, %v, want %+v", c.path, got, err, c.want)
+		}
+	}
+
+	for _, path := range []string{"$.ports[0", "$.ports[-1]", "$.ports[x]", "$..id", "$.ports.[0]."} {
+		if _, err := parseJSONPath(path); err == nil {
+			t.Errorf("parseJSONPath(%s) succeeded, want an error", path)
+		}
+	}
+}
+
+func TestJSONPathEval(t *testing.T) {
+	doc := map[string]interface{}{
+		"device": "olt-1",
+		"ports": []interface{}{
+			map[string]interface{}{"id": "p0", "rx": float64(1)},
+			map[string]interface{}{"id": "p1", "rx": float64(2)},
+		},
+		"totals": map[string]interface{}{"rx": float64(3), "tx": float64(4)},
+	}
+	record := doc["ports"].([]interface{})[1]
+
+	for _, c := range []struct {
+		path string
+		want []interface{}
+	}{
+		{"$.device", []interface{}{"olt-1"}},
+		{"$.ports[*].id", []interface{}{"p0", "p1"}},
+		{"$.ports[0].rx", []interface{}{float64(1)}},
+		{"$.totals[*]", []interface{}{float64(3), float64(4)}},
+		{"id", []interface{}{"p1"}},
+		// missing keys, out of range indexes and mismatched steps
+		{"$.missing", nil},
+		{"$.ports[2].id", nil},
+		{"$.ports.id", nil},
+		{"$.totals[0]", nil},
+		{"$.device.id", nil},
+	} {
+		path, err := parseJSONPath(c.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := path.eval(doc, record)
+		sort.Slice(got, func(i, j int) bool { return jsonLabel(got[i]) < jsonLabel(got[j]) })
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("eval(%s) = %v, want %v", c.path, got, c.want)
+		}
+	}
+}
+
+func TestJSONValue(t *testing.T) {
+	for _, c := range []struct {
+		node  interface{}
+		value float64
+		ok    bool
+	}{
+		{float64(1.5), 1.5, true},
+		{"2.5", 2.5, true},
+		{true, 1, true},
+		{false, 0, true},
+		{"up", 0, false},
+		{nil, 0, false},
+		{map[string]interface{}{}, 0, false},
+		{[]interface{}{float64(1)}, 0, false},
+	} {
+		if value, ok := jsonValue(c.node); value != c.value || ok != c.ok {
+			t.Errorf("jsonValue(%v) = %v, %v, want %v, %v", c.node, value, ok, c.value, c.ok)
+		}
+	}
+}
+
+func TestMappingDecoder(t *testing.T) {
+	defer removeTracker("test_mapped_rx_bytes")
+	registerMappings([]MappingInfo{{
+		Decoder: "test-mapping-decoder",
+		Metrics: []MetricMappingInfo{{
+			Name:    "test_mapped_rx_bytes",
+			Type:    "counter",
+			Records: "$.ports[*]",
+			Labels:  map[string]string{"device_id": "$.device", "port": "id"},
+			Value:   "rx",
+		}},
+	}})
+
+	msg := &Message{Cluster: "test", Value: []byte(`{
+		"device": "olt-1",
+		"ports": [
+			{"id": "p0", "rx": 10},
+			{"id": "p1", "rx": "20"},
+			{"id": "p2", "rx": "down"},
+			{"id": "p3"}
+		]
+	}`)}
+	if err := export("test-mapping-decoder", msg); err != nil {
+		t.Fatal(err)
+	}
+
+	got := map[string]float64{}
+	for _, m := range gather(t, decoders["test-mapping-decoder"].(*mappingDecoder).metrics[0]) {
+		if labelValue(m, "cluster") != "test" || labelValue(m, "device_id") != "olt-1" {
+			t.Errorf("unexpected labels %v", m.Label)
+		}
+		got[labelValue(m, "port")] = m.GetCounter().GetValue()
+	}
+	if want := map[string]float64{"p0": 10, "p1": 20}; !reflect.DeepEqual(got, want) {
+		t.Errorf("gathered %v, want %v", got, want)
+	}
+
+	if err := export("test-mapping-decoder", &Message{Value: []byte(`{"ports": [`)}); errorReason(err) != reasonUnmarshal {
+		t.Errorf("invalid JSON: error %v, want %s", err, reasonUnmarshal)
+	}
+}
+
+func TestRegisterMappingsDuplicateMetric(t *testing.T) {
+	defer removeTracker("test_mapped_duplicate")
+	metric := func(label string) MetricMappingInfo {
+		return MetricMappingInfo{
+			Name:   "test_mapped_duplicate",
+			Labels: map[string]string{label: "$.id"},
+			Value:  "$.value",
+		}
+	}
+	registerMappings([]MappingInfo{
+		{Decoder: "test-mapping-1", Metrics: []MetricMappingInfo{metric("device_id")}},
+		{Decoder: "test-mapping-2", Metrics: []MetricMappingInfo{metric("port_id")}},
+	})
+
+	if n := len(decoders["test-mapping-2"].(*mappingDecoder).metrics); n != 0 {
+		t.Errorf("%d metrics registered for the duplicate, want 0", n)
+	}
+	relabelMu.Lock()
+	defer relabelMu.Unlock()
+	var relabeled []relabeledFamily
+	for _, rf := range relabelFamilies {
+		if rf.family == "test_mapped_duplicate" {
+			relabeled = append(relabeled, rf)
+		}
+	}
+	if len(relabeled) != 1 || relabeled[0].names[1] != "device_id" {
+		t.Errorf("relabeled families = %v, want the registered metric only", relabeled)
+	}
+}
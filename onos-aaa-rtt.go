@@ -0,0 +1,135 @@
+// Copyright 2019 Open Networking Foundation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"gerrit.opencord.org/kafka-topic-exporter/common/logger"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const onosaaaRequestRttName = "onosaaa_request_rtt_milliseconds"
+
+var (
+	defaultRttBuckets    = []float64{1, 2.5, 5, 10, 25, 50, 100, 250, 500, 1000, 2500, 5000}
+	defaultRttObjectives = map[float64]float64{0.5: 0.05, 0.9: 0.01, 0.99: 0.001}
+
+	// distribution of the AAA round trip times, set by configureRtt
+	onosaaaRequestRtt *observerVec
+)
+
+// observerVec is a HistogramVec or a SummaryVec whose series may expire, are
+// relabeled and limited
+type observerVec struct {
+	deletableObserverVec
+	newVec     func(labels []string) deletableObserverVec
+	tracker    *seriesTracker
+	relabeling *relabeling
+}
+
+type deletableObserverVec interface {
+	prometheus.ObserverVec
+	DeleteLabelValues(labels ...string) bool
+}
+
+func newObserverVec(name string, labels []string, newVec func(labels []string) deletableObserverVec) *observerVec {
+	v := &observerVec{
+		deletableObserverVec: newVec(labels),
+		newVec:               newVec,
+	}
+	v.tracker = newSeriesTracker(name, func(labels []string) { v.deletableObserverVec.DeleteLabelValues(labels...) })
+	registerRelabelable(name, labels, v)
+	return v
+}
+
+func (v *observerVec) relabel(r *relabeling) {
+	if r == nil {
+		return
+	}
+	v.relabeling = r
+	v.deletableObserverVec = v.newVec(r.labels(nil))
+}
+
+func (v *observerVec) WithLabelValues(labels ...string) prometheus.Observer {
+	labels, keep := v.relabeling.apply(labels)
+	if keep {
+		labels, keep = v.tracker.admit(labels)
+	}
+	if !keep {
+		return discardedObserver
+	}
+	return v.deletableObserverVec.WithLabelValues(labels...)
+}
+
+// configureRtt creates and registers the AAA round trip time distribution
+func configureRtt(info RttInfo) {
+	labels := []string{"cluster", "onos_instance"}
+	help := "Distribution of the roundtrip packet times to the accounting server in milliseconds"
+
+	switch info.Type {
+	case "", "histogram":
+		buckets := info.Buckets
+		if len(buckets) == 0 {
+			buckets = defaultRttBuckets
+		} else if !increasing(buckets) {
+			logger.Error("Invalid aaa_rtt buckets %v, they must be strictly increasing, using default %v",
+				buckets, defaultRttBuckets)
+			buckets = defaultRttBuckets
+		}
+		onosaaaRequestRtt = newObserverVec(onosaaaRequestRttName, labels, func(labels []string) deletableObserverVec {
+			return prometheus.NewHistogramVec(
+				prometheus.HistogramOpts{
+					Name:    onosaaaRequestRttName,
+					Help:    help,
+					Buckets: buckets,
+				},
+				labels,
+			)
+		})
+	case "summary":
+		objectives := info.Objectives
+		if len(objectives) == 0 {
+			objectives = defaultRttObjectives
+		}
+		onosaaaRequestRtt = newObserverVec(onosaaaRequestRttName, labels, func(labels []string) deletableObserverVec {
+			return prometheus.NewSummaryVec(
+				prometheus.SummaryOpts{
+					Name:       onosaaaRequestRttName,
+					Help:       help,
+					Objectives: objectives,
+				},
+				labels,
+			)
+		})
+	default:
+		logger.Error("Invalid aaa_rtt type [%s], the AAA round trip times won't be exported", info.Type)
+		return
+	}
+
+	if err := prometheus.Register(onosaaaRequestRtt); err != nil {
+		logger.Error("Cannot register %s: %s", onosaaaRequestRttName, err)
+		onosaaaRequestRtt = nil
+	}
+}
+
+// increasing returns true if the values are strictly increasing, as the
+// histogram buckets must be
+func increasing(values []float64) bool {
+	for i := 1; i < len(values); i++ {
+		if values[i] <= values[i-1] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,56 @@
+// Copyright 2019 Open Networking Foundation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestConfigureRttBuckets(t *testing.T) {
+	defer removeTracker(onosaaaRequestRttName)
+
+	for _, c := range []struct {
+		buckets []float64
+		want    []float64
+	}{
+		{nil, defaultRttBuckets},
+		{[]float64{10, 5, 20}, defaultRttBuckets},
+		{[]float64{5, 5, 10}, defaultRttBuckets},
+		{[]float64{5, 10, 20}, []float64{5, 10, 20}},
+	} {
+		configureRtt(RttInfo{Buckets: c.buckets})
+		if onosaaaRequestRtt == nil {
+			t.Fatalf("buckets %v: not registered", c.buckets)
+		}
+		// the histogram is created on the first observation
+		onosaaaRequestRtt.WithLabelValues("onos", "instance").Observe(7)
+
+		metrics := gather(t, onosaaaRequestRtt)
+		prometheus.Unregister(onosaaaRequestRtt)
+		if len(metrics) != 1 {
+			t.Fatalf("buckets %v: %d series gathered, want 1", c.buckets, len(metrics))
+		}
+		var bounds []float64
+		for _, b := range metrics[0].GetHistogram().GetBucket() {
+			bounds = append(bounds, b.GetUpperBound())
+		}
+		if !reflect.DeepEqual(bounds, c.want) {
+			t.Errorf("buckets %v: histogram buckets %v, want %v", c.buckets, bounds, c.want)
+		}
+	}
+}
@@ -0,0 +1,286 @@
+// Copyright 2019 Open Networking Foundation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gerrit.opencord.org/kafka-topic-exporter/common/logger"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	// interval_end_time is an 8 bits counter of the 15 minutes intervals
+	pmIntervalWrap = 256
+	// intervals a wrap around may skip, e.g. when the exporter was down
+	pmMaxIntervalGap = 4
+
+	defaultPMHistorySaveInterval = time.Minute
+)
+
+var (
+	volthaOnuPmIntervalResets = newCounterVec(
+		prometheus.CounterOpts{
+			Name: "voltha_onu_pm_interval_resets_total",
+			Help: "Number of times the ONU PM history intervals restarted, e.g. because the ONU rebooted",
+		},
+		[]string{"cluster", "device_id", "title"},
+	)
+
+	// PM history titles, for persistence
+	pmHistories = make(map[string]*pmHistory)
+)
+
+// pmCounter is a counter accumulated from a field of the PM history
+type pmCounter struct {
+	vec   *counterVec
+	value func(m *Metrics) float64
+}
+
+// pmHistory accumulates the ONU PM history of a title into counters. Each
+// message reports the counts of the last 15 minutes interval of an entity,
+// they are added to the counters once, so replayed or repeated intervals are
+// not counted twice.
+type pmHistory struct {
+	title  string
+	labels func(cluster string, data *SliceData) []string
+	// by name, the name is used to save the totals
+	counters map[string]pmCounter
+
+	mu     sync.Mutex
+	series map[string]*pmSeries
+	dirty  bool
+}
+
+// pmSeries is the accumulated state of an entity
+type pmSeries struct {
+	Labels []string           `json:"labels"`
+	Totals map[string]float64 `json:"totals"`
+	// timestamp of the last accounted interval
+	Timestamp float64 `json:"ts"`
+	// interval_end_time of the last accounted interval, -1 if unknown
+	Interval float64 `json:"interval"`
+}
+
+func newPMHistory(title string, labels func(cluster string, data *SliceData) []string, counters map[string]pmCounter) *pmHistory {
+	h := &pmHistory{
+		title:    title,
+		labels:   labels,
+		counters: counters,
+		series:   make(map[string]*pmSeries),
+	}
+	for _, c := range counters {
+		vec := c.vec
+		vec.tracker.onExpire = func(labels []string) { h.forget(vec, labels) }
+	}
+	pmHistories[title] = h
+	return h
+}
+
+// pmEntityID returns the OMCI entity of the PM history as a label value
+func pmEntityID(m *Metrics) string {
+	if m.EntityID == nil {
+		return ""
+	}
+	return strconv.FormatFloat(*m.EntityID, 'f', -1, 64)
+}
+
+// observe adds the counts of an interval to the counters of its entity
+func (h *pmHistory) observe(cluster string, data *SliceData) error {
+	if err := validateSliceData(data); err != nil {
+		return &exportError{reason: reasonInvalidKPI, err: err}
+	}
+	labels := h.labels(cluster, data)
+	key := strings.Join(labels, "\xff")
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	s, ok := h.series[key]
+	if !ok {
+		s = &pmSeries{Labels: labels, Totals: make(map[string]float64), Interval: -1}
+		h.series[key] = s
+	}
+
+	ts := data.Metadata.Timestamp
+	if ts > 0 && ts <= s.Timestamp {
+		logger.Debug("Skipping %s of %s at %f, already accounted", h.title, data.Metadata.DeviceID, ts)
+		return nil
+	}
+	if interval := data.Metrics.IntervalEndTime; interval != nil {
+		if s.Interval >= 0 {
+			if *interval == s.Interval {
+				logger.Debug("Skipping %s of %s for interval %.0f, already accounted",
+					h.title, data.Metadata.DeviceID, *interval)
+				return nil
+			}
+			wrapped := *interval+pmIntervalWrap-s.Interval <= pmMaxIntervalGap
+			if *interval < s.Interval && !wrapped {
+				logger.Info("%s intervals of %s restarted from %.0f to %.0f",
+					h.title, data.Metadata.DeviceID, s.Interval, *interval)
+				volthaOnuPmIntervalResets.WithLabelValues(cluster, data.Metadata.DeviceID, h.title).Inc()
+			}
+		}
+		s.Interval = *interval
+	}
+	if ts > 0 {
+		s.Timestamp = ts
+	}
+
+	for name, c := range h.counters {
+		// every counter is updated, so that they expire together
+		v := c.value(data.Metrics)
+		if v < 0 {
+			v = 0
+		}
+		c.vec.at(kpiTime(ts)).WithLabelValues(labels...).Add(v)
+		s.Totals[name] += v
+	}
+	h.dirty = true
+	return nil
+}
+
+// restore sets the counters from saved series
+func (h *pmHistory) restore(series map[string]*pmSeries) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for _, s := range series {
+		if s.Totals == nil {
+			s.Totals = make(map[string]float64)
+		}
+		for name, total := range s.Totals {
+			c, ok := h.counters[name]
+			if !ok {
+				continue
+			}
+			if err := c.vec.restore(s.Labels, total); err != nil {
+				logger.Warn("Cannot restore %s %s: %s", h.title, name, err)
+				continue
+			}
+		}
+		// the keys of the state are not valid UTF-8, JSON mangled them
+		h.series[strings.Join(s.Labels, "\xff")] = s
+	}
+}
+
+// forget drops the state of the entities of an expired series, so that they
+// are not restored
+func (h *pmHistory) forget(vec *counterVec, labels []string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	key := strings.Join(labels, "\xff")
+	if vec.relabeling == nil {
+		if _, ok := h.series[key]; ok {
+			delete(h.series, key)
+			h.dirty = true
+		}
+		return
+	}
+	// relabeling may have merged the series of several entities
+	for k, s := range h.series {
+		if relabeled, keep := vec.relabeling.apply(s.Labels); keep && strings.Join(relabeled, "\xff") == key {
+			delete(h.series, k)
+			h.dirty = true
+		}
+	}
+}
+
+// loadPMHistory restores the counters saved in the file
+func loadPMHistory(file string) {
+	b, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		return
+	}
+	if err != nil {
+		logger.Error("Cannot read PM history state: %s", err)
+		return
+	}
+	state := make(map[string]map[string]*pmSeries)
+	if err := json.Unmarshal(b, &state); err != nil {
+		logger.Error("Cannot decode PM history state %s: %s", file, err)
+		return
+	}
+	for title, series := range state {
+		if h, ok := pmHistories[title]; ok {
+			h.restore(series)
+			logger.Info("Restored %d %s series", len(series), title)
+		}
+	}
+}
+
+// savePMHistory saves the counters to the file, if any changed since the
+// last save
+func savePMHistory(file string) {
+	dirty := false
+	state := make(map[string]map[string]*pmSeries)
+	for title, h := range pmHistories {
+		h.mu.Lock()
+		dirty = dirty || h.dirty
+		h.dirty = false
+		series := make(map[string]*pmSeries, len(h.series))
+		for key, s := range h.series {
+			totals := make(map[string]float64, len(s.Totals))
+			for name, total := range s.Totals {
+				totals[name] = total
+			}
+			copy := *s
+			copy.Totals = totals
+			series[key] = &copy
+		}
+		h.mu.Unlock()
+		state[title] = series
+	}
+	if !dirty {
+		return
+	}
+
+	b, err := json.Marshal(state)
+	if err == nil {
+		// write then rename, so that a crash never leaves a partial file
+		tmp := filepath.Join(filepath.Dir(file), "."+filepath.Base(file)+".tmp")
+		if err = ioutil.WriteFile(tmp, b, 0644); err == nil {
+			err = os.Rename(tmp, file)
+		}
+	}
+	if err != nil {
+		logger.Error("Cannot save PM history state: %s", err)
+	}
+}
+
+// runPMHistoryState saves the counters periodically until the context is
+// cancelled
+func runPMHistoryState(ctx context.Context, info StateInfo) {
+	interval := defaultPMHistorySaveInterval
+	if info.SaveInterval != "" {
+		var err error
+		if interval, err = time.ParseDuration(info.SaveInterval); err != nil || interval <= 0 {
+			logger.Error("Invalid state save_interval [%s], using default %s", info.SaveInterval, defaultPMHistorySaveInterval)
+			interval = defaultPMHistorySaveInterval
+		}
+	}
+	for wait(ctx, interval) {
+		savePMHistory(info.File)
+	}
+}
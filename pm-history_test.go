@@ -0,0 +1,235 @@
+// Copyright 2019 Open Networking Foundation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// newTestPMHistory returns a history of the tx_bytes of the devices
+func newTestPMHistory(title string) (*pmHistory, *counterVec) {
+	vec := newCounterVec(
+		prometheus.CounterOpts{Name: "test_pm_history_tx_bytes_total", Help: "test"},
+		[]string{"cluster", "device_id"},
+	)
+	h := newPMHistory(title, func(cluster string, data *SliceData) []string {
+		return []string{cluster, data.Metadata.DeviceID}
+	}, map[string]pmCounter{
+		"tx_bytes": {vec, func(m *Metrics) float64 { return m.TxBytes }},
+	})
+	return h, vec
+}
+
+// pmInterval returns the slice of a device for an interval, ts and interval
+// are omitted when not positive
+func pmInterval(device string, ts float64, interval float64, txBytes float64) *SliceData {
+	data := &SliceData{
+		Metrics:  &Metrics{TxBytes: txBytes},
+		Metadata: &Metadata{DeviceID: device, Timestamp: ts, Context: &Context{}},
+	}
+	if interval >= 0 {
+		data.Metrics.IntervalEndTime = &interval
+	}
+	return data
+}
+
+// pmTotals returns the gathered counters by device
+func pmTotals(t *testing.T, vec *counterVec) map[string]float64 {
+	totals := make(map[string]float64)
+	for _, m := range gather(t, vec) {
+		totals[labelValue(m, "device_id")] = m.GetCounter().GetValue()
+	}
+	return totals
+}
+
+// pmResets returns the gathered interval resets of a history
+func pmResets(t *testing.T, title string) float64 {
+	for _, m := range gather(t, volthaOnuPmIntervalResets) {
+		if labelValue(m, "title") == title {
+			return m.GetCounter().GetValue()
+		}
+	}
+	return 0
+}
+
+func TestPMHistoryObserve(t *testing.T) {
+	h, vec := newTestPMHistory("test-observe")
+	defer delete(pmHistories, "test-observe")
+	defer removeTracker("test_pm_history_tx_bytes_total")
+	for _, c := range []struct {
+		data *SliceData
+		// total of the device after the interval
+		total float64
+	}{
+		{pmInterval("ts", 100, -1, 1), 1},
+		// replayed, then older
+		{pmInterval("ts", 100, -1, 2), 1},
+		{pmInterval("ts", 90, -1, 4), 1},
+		{pmInterval("ts", 110, -1, 8), 9},
+
+		{pmInterval("interval", 0, 10, 1), 1},
+		{pmInterval("interval", 0, 10, 2), 1},
+		{pmInterval("interval", 0, 11, 4), 5},
+
+		// both a newer ts and a repeated interval
+		{pmInterval("both", 100, 10, 1), 1},
+		{pmInterval("both", 200, 10, 2), 1},
+		{pmInterval("both", 100, 11, 4), 1},
+
+		// negative counts are not added
+		{pmInterval("negative", 100, -1, -1), 0},
+	} {
+		if err := h.observe("test", c.data); err != nil {
+			t.Fatal(err)
+		}
+		device := c.data.Metadata.DeviceID
+		if total := pmTotals(t, vec)[device]; total != c.total {
+			t.Errorf("%s total %v after ts %v interval %v, want %v",
+				device, total, c.data.Metadata.Timestamp, c.data.Metrics.IntervalEndTime, c.total)
+		}
+	}
+	if resets := pmResets(t, "test-observe"); resets != 0 {
+		t.Errorf("%v interval resets, want 0", resets)
+	}
+}
+
+func TestPMHistoryIntervalWrap(t *testing.T) {
+	defer removeTracker("test_pm_history_tx_bytes_total")
+	for _, c := range []struct {
+		from, to float64
+		resets   float64
+	}{
+		// 255 is followed by 0
+		{pmIntervalWrap - 1, 0, 0},
+		{pmIntervalWrap - 2, pmMaxIntervalGap - 2, 0},
+		{pmIntervalWrap - 1, pmMaxIntervalGap - 1, 0},
+		{pmIntervalWrap - 1, pmMaxIntervalGap, 1},
+		{pmIntervalWrap - 2, pmMaxIntervalGap - 1, 1},
+		{100, 50, 1},
+		{100, 101, 0},
+	} {
+		title := "test-wrap"
+		h, vec := newTestPMHistory(title)
+		defer delete(pmHistories, title)
+		before := pmResets(t, title)
+		for i, interval := range []float64{c.from, c.to} {
+			if err := h.observe("test", pmInterval("wrap", 0, interval, 1)); err != nil {
+				t.Fatal(err)
+			}
+			if total := pmTotals(t, vec)["wrap"]; total != float64(i+1) {
+				t.Errorf("%v to %v: total %v after interval %v, want %d", c.from, c.to, total, interval, i+1)
+			}
+		}
+		if resets := pmResets(t, title) - before; resets != c.resets {
+			t.Errorf("%v to %v: %v interval resets, want %v", c.from, c.to, resets, c.resets)
+		}
+		vec.Reset()
+	}
+}
+
+func TestPMHistoryState(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pm-history")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer removeTracker("test_pm_history_tx_bytes_total")
+	file := filepath.Join(dir, "state.json")
+
+	// the state of the other histories is neither saved nor restored
+	histories := pmHistories
+	pmHistories = make(map[string]*pmHistory)
+	defer func() { pmHistories = histories }()
+
+	h, vec := newTestPMHistory("test-state")
+	for _, data := range []*SliceData{
+		pmInterval("olt-1", 100, 10, 3),
+		pmInterval("olt-1", 200, 11, 4),
+		pmInterval("olt-2", 100, -1, 5),
+	} {
+		if err := h.observe("test", data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	savePMHistory(file)
+	if _, err := os.Stat(file); err != nil {
+		t.Fatal(err)
+	}
+
+	// a restarted exporter
+	vec.Reset()
+	restored, vec := newTestPMHistory("test-state")
+	loadPMHistory(file)
+
+	if totals := pmTotals(t, vec); totals["olt-1"] != 7 || totals["olt-2"] != 5 {
+		t.Errorf("restored totals %v, want olt-1 7 and olt-2 5", totals)
+	}
+	for key, s := range h.series {
+		r, ok := restored.series[key]
+		if !ok || r.Timestamp != s.Timestamp || r.Interval != s.Interval || r.Totals["tx_bytes"] != s.Totals["tx_bytes"] {
+			t.Errorf("restored series %+v, want %+v", r, s)
+		}
+	}
+
+	// the restored intervals are not counted again
+	if err := restored.observe("test", pmInterval("olt-1", 200, 11, 4)); err != nil {
+		t.Fatal(err)
+	}
+	if err := restored.observe("test", pmInterval("olt-2", 100, -1, 5)); err != nil {
+		t.Fatal(err)
+	}
+	if totals := pmTotals(t, vec); totals["olt-1"] != 7 || totals["olt-2"] != 5 {
+		t.Errorf("totals %v after replayed intervals, want olt-1 7 and olt-2 5", totals)
+	}
+}
+
+func TestUniHistoryEntities(t *testing.T) {
+	// the two UNIs of an ONU report the same interval, with the same
+	// context
+	msg := &Message{
+		Cluster: "voltha",
+		Topic:   "voltha.kpis",
+		Value: []byte(`{"type": "slice", "ts": 1536617075, "slice_data": [
+			{
+				"metrics": {"entity_id": 257, "interval_end_time": 10, "fcs_errors": 1},
+				"metadata": {"title": "Ethernet_UNI_History", "ts": 1536617075, "device_id": "test-uni-onu",
+					"context": {"parent_class_id": "11", "parent_entity_id": "257"}}
+			},
+			{
+				"metrics": {"entity_id": 258, "interval_end_time": 10, "fcs_errors": 2},
+				"metadata": {"title": "Ethernet_UNI_History", "ts": 1536617075, "device_id": "test-uni-onu",
+					"context": {"parent_class_id": "11", "parent_entity_id": "257"}}
+			}
+		]}`),
+	}
+	if err := export("voltha", msg); err != nil {
+		t.Fatal(err)
+	}
+
+	got := make(map[string]float64)
+	for _, m := range gather(t, volthaOnuUniFcsErrorsTotal) {
+		if labelValue(m, "device_id") == "test-uni-onu" {
+			got[labelValue(m, "entity_id")] = m.GetCounter().GetValue()
+		}
+	}
+	if len(got) != 2 || got["257"] != 1 || got["258"] != 2 {
+		t.Errorf("fcs errors by entity %v, want 257: 1 and 258: 2", got)
+	}
+}
@@ -0,0 +1,350 @@
+// Copyright 2019 Open Networking Foundation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"crypto/md5"
+	"encoding/binary"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	"gerrit.opencord.org/kafka-topic-exporter/common/logger"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	relabelReplace   = "replace"
+	relabelKeep      = "keep"
+	relabelDrop      = "drop"
+	relabelHashMod   = "hashmod"
+	relabelLabelMap  = "labelmap"
+	relabelLabelDrop = "labeldrop"
+	relabelLabelKeep = "labelkeep"
+
+	// the metric family name, available to source_labels
+	metricNameLabel = "__name__"
+)
+
+var (
+	labelNameRE = regexp.MustCompile("^[a-zA-Z_][a-zA-Z0-9_]*$")
+
+	// relabel rules of the configuration, and the families they apply to,
+	// families created later are relabeled when created
+	relabelMu       sync.Mutex
+	relabelRules    []*relabelRule
+	relabelFamilies []relabeledFamily
+
+	// dropped series are updated, but never collected
+	discardedGauge    = prometheus.NewGauge(prometheus.GaugeOpts{Name: "discarded"})
+	discardedCounter  = prometheus.NewCounter(prometheus.CounterOpts{Name: "discarded"})
+	discardedObserver = prometheus.NewHistogram(prometheus.HistogramOpts{Name: "discarded"})
+)
+
+// relabelable is a metric family whose labels can be relabeled
+type relabelable interface {
+	// relabel rebuilds the family with the labels of the relabeling, nil
+	// keeps the declared labels
+	relabel(r *relabeling)
+}
+
+type relabeledFamily struct {
+	family string
+	names  []string
+	f      relabelable
+}
+
+// relabelRule is a Prometheus relabel_config
+type relabelRule struct {
+	RelabelInfo
+	regexp *regexp.Regexp
+}
+
+func newRelabelRule(info RelabelInfo) (*relabelRule, error) {
+	r := &relabelRule{RelabelInfo: info}
+	if r.Action == "" {
+		r.Action = relabelReplace
+	}
+	if r.Regex == "" {
+		r.Regex = "(.*)"
+	}
+	if r.Separator == nil {
+		separator := ";"
+		r.Separator = &separator
+	}
+	if r.Replacement == nil {
+		replacement := "$1"
+		r.Replacement = &replacement
+	}
+
+	var err error
+	if r.regexp, err = regexp.Compile("^(?:" + r.Regex + ")$"); err != nil {
+		return nil, err
+	}
+	switch r.Action {
+	case relabelReplace, relabelHashMod:
+		// label names are fixed per family, so the target cannot depend
+		// on the label values
+		if !labelNameRE.MatchString(r.TargetLabel) {
+			return nil, fmt.Errorf("invalid target_label [%s] for %s", r.TargetLabel, r.Action)
+		}
+		if r.Action == relabelHashMod && r.Modulus == 0 {
+			return nil, fmt.Errorf("modulus is required for hashmod")
+		}
+	case relabelKeep, relabelDrop, relabelLabelMap, relabelLabelDrop, relabelLabelKeep:
+	default:
+		return nil, fmt.Errorf("unknown action [%s]", r.Action)
+	}
+	return r, nil
+}
+
+// names returns the label names once relabeled, labels a rule may not set
+// for some series are exported with an empty value, i.e. as missing
+func (r *relabelRule) names(names []string) []string {
+	has := func(name string) bool {
+		for _, n := range names {
+			if n == name {
+				return true
+			}
+		}
+		return false
+	}
+
+	switch r.Action {
+	case relabelReplace, relabelHashMod:
+		if !has(r.TargetLabel) {
+			names = append(names, r.TargetLabel)
+		}
+	case relabelLabelMap:
+		for _, name := range names {
+			if r.regexp.MatchString(name) {
+				if target := r.regexp.ReplaceAllString(name, *r.Replacement); !has(target) {
+					names = append(names, target)
+				}
+			}
+		}
+	case relabelLabelDrop, relabelLabelKeep:
+		var kept []string
+		for _, name := range names {
+			if r.regexp.MatchString(name) == (r.Action == relabelLabelKeep) || name == metricNameLabel {
+				kept = append(kept, name)
+			}
+		}
+		names = kept
+	}
+	return names
+}
+
+// inert returns true if a replace rule never applies to a family, i.e. its
+// source labels are missing and the regex doesn't match their empty values,
+// so that it doesn't add its target label to every family
+func (r *relabelRule) inert(names []string) bool {
+	if r.Action != relabelReplace {
+		return false
+	}
+	for _, source := range r.SourceLabels {
+		for _, name := range names {
+			if source == name {
+				return false
+			}
+		}
+	}
+	// without source labels the joined value is empty, e.g. to set a
+	// constant label
+	value := ""
+	if len(r.SourceLabels) > 0 {
+		value = strings.Repeat(*r.Separator, len(r.SourceLabels)-1)
+	}
+	return !r.regexp.MatchString(value)
+}
+
+// apply relabels the labels of a series, it returns false if the series
+// is dropped
+func (r *relabelRule) apply(labels map[string]string) bool {
+	values := make([]string, len(r.SourceLabels))
+	for i, name := range r.SourceLabels {
+		values[i] = labels[name]
+	}
+	value := strings.Join(values, *r.Separator)
+
+	switch r.Action {
+	case relabelKeep:
+		return r.regexp.MatchString(value)
+	case relabelDrop:
+		return !r.regexp.MatchString(value)
+	case relabelReplace:
+		match := r.regexp.FindStringSubmatchIndex(value)
+		if match == nil {
+			break
+		}
+		if res := string(r.regexp.ExpandString(nil, *r.Replacement, value, match)); res != "" {
+			labels[r.TargetLabel] = res
+		} else {
+			delete(labels, r.TargetLabel)
+		}
+	case relabelHashMod:
+		sum := md5.Sum([]byte(value))
+		labels[r.TargetLabel] = strconv.FormatUint(binary.BigEndian.Uint64(sum[8:])%r.Modulus, 10)
+	case relabelLabelMap:
+		// the labels are mapped from their values before the rule, a label
+		// the rule sets is not mapped again
+		mapped := make(map[string]string)
+		for name, v := range labels {
+			if r.regexp.MatchString(name) {
+				mapped[r.regexp.ReplaceAllString(name, *r.Replacement)] = v
+			}
+		}
+		for name, v := range mapped {
+			labels[name] = v
+		}
+	case relabelLabelDrop, relabelLabelKeep:
+		for name := range labels {
+			if name != metricNameLabel && r.regexp.MatchString(name) != (r.Action == relabelLabelKeep) {
+				delete(labels, name)
+			}
+		}
+	}
+	return true
+}
+
+// relabeling applies the relabel rules to the series of a metric family
+type relabeling struct {
+	family string
+	// label names as declared, and once relabeled
+	names []string
+	out   []string
+	rules []*relabelRule
+}
+
+// newRelabeling returns the relabeling of a family, nil without rules
+func newRelabeling(family string, names []string, rules []*relabelRule) (*relabeling, error) {
+	if len(rules) == 0 {
+		return nil, nil
+	}
+	r := &relabeling{family: family, names: names}
+	out := append([]string{metricNameLabel}, names...)
+	for _, rule := range rules {
+		if rule.inert(out) {
+			continue
+		}
+		out = rule.names(out)
+		r.rules = append(r.rules, rule)
+	}
+	for _, name := range out {
+		// labels starting with __ are only available to the rules
+		if strings.HasPrefix(name, "__") {
+			continue
+		}
+		if !labelNameRE.MatchString(name) {
+			return nil, fmt.Errorf("invalid label name [%s]", name)
+		}
+		r.out = append(r.out, name)
+	}
+	return r, nil
+}
+
+// labels returns the label names of the family once relabeled
+func (r *relabeling) labels(names []string) []string {
+	if r == nil {
+		return names
+	}
+	return r.out
+}
+
+// apply relabels the label values of a series, in the order of the declared
+// label names, it returns false if the series is dropped
+func (r *relabeling) apply(values []string) ([]string, bool) {
+	if r == nil {
+		return values, true
+	}
+
+	labels := make(map[string]string, len(r.names)+1)
+	labels[metricNameLabel] = r.family
+	for i, name := range r.names {
+		if i < len(values) {
+			labels[name] = values[i]
+		}
+	}
+	for _, rule := range r.rules {
+		if !rule.apply(labels) {
+			return nil, false
+		}
+	}
+
+	out := make([]string, len(r.out))
+	for i, name := range r.out {
+		out[i] = labels[name]
+	}
+	return out, true
+}
+
+// registerRelabelable relabels a family with the configured rules, now and
+// whenever they are configured
+func registerRelabelable(family string, names []string, f relabelable) {
+	f.relabel(configuredRelabeling(family, names))
+	trackRelabelable(family, names, f)
+}
+
+// trackRelabelable relabels a family whenever the rules are configured
+func trackRelabelable(family string, names []string, f relabelable) {
+	relabelMu.Lock()
+	defer relabelMu.Unlock()
+	relabelFamilies = append(relabelFamilies, relabeledFamily{family: family, names: names, f: f})
+}
+
+// configuredRelabeling returns the relabeling of a family with the
+// configured rules, nil without rules
+func configuredRelabeling(family string, names []string) *relabeling {
+	relabelMu.Lock()
+	defer relabelMu.Unlock()
+	return familyRelabeling(family, names)
+}
+
+// familyRelabeling returns the relabeling of a family, families whose labels
+// cannot be relabeled keep them
+func familyRelabeling(family string, names []string) *relabeling {
+	r, err := newRelabeling(family, names, relabelRules)
+	if err != nil {
+		logger.Error("Cannot relabel [%s], keeping its labels: %s", family, err)
+		return nil
+	}
+	return r
+}
+
+// configureRelabel sets the relabel rules, it must be called before the
+// families are registered and before any series is created
+func configureRelabel(infos []RelabelInfo) {
+	relabelMu.Lock()
+	defer relabelMu.Unlock()
+
+	relabelRules = nil
+	for i, info := range infos {
+		rule, err := newRelabelRule(info)
+		if err != nil {
+			logger.Error("Invalid relabel_configs[%d], skipping it: %s", i, err)
+			continue
+		}
+		relabelRules = append(relabelRules, rule)
+	}
+	if len(relabelRules) == 0 {
+		return
+	}
+	for _, rf := range relabelFamilies {
+		rf.f.relabel(familyRelabeling(rf.family, rf.names))
+	}
+	logger.Info("Relabeling metrics with %d rules", len(relabelRules))
+}
@@ -0,0 +1,77 @@
+// Copyright 2019 Open Networking Foundation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestRelabelConstantLabel(t *testing.T) {
+	replacement := "pod1"
+	rule, err := newRelabelRule(RelabelInfo{TargetLabel: "site", Replacement: &replacement})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := newRelabeling("voltha_rx_bytes_total", []string{"cluster", "device_id"}, []*relabelRule{rule})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := []string{"cluster", "device_id", "site"}; !reflect.DeepEqual(r.labels(nil), want) {
+		t.Errorf("labels = %v, want %v", r.labels(nil), want)
+	}
+	values, keep := r.apply([]string{"voltha", "dev1"})
+	if want := []string{"voltha", "dev1", "pod1"}; !keep || !reflect.DeepEqual(values, want) {
+		t.Errorf("apply = %v, %v, want %v", values, keep, want)
+	}
+}
+
+func TestRelabelInertReplace(t *testing.T) {
+	rule, err := newRelabelRule(RelabelInfo{SourceLabels: []string{"interface_id"}, Regex: "NA", TargetLabel: "interface_id"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := newRelabeling("onos_rx_bytes_total", []string{"cluster", "port_id"}, []*relabelRule{rule})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"cluster", "port_id"}; !reflect.DeepEqual(r.labels(nil), want) {
+		t.Errorf("labels = %v, want %v", r.labels(nil), want)
+	}
+}
+
+func TestRelabelLabelMapOriginalValues(t *testing.T) {
+	rule, err := newRelabelRule(RelabelInfo{Action: relabelLabelMap, Regex: "a(.*)"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := newRelabeling("test_labelmap", []string{"aab", "ab"}, []*relabelRule{rule})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"aab", "ab", "b"}; !reflect.DeepEqual(r.labels(nil), want) {
+		t.Fatalf("labels = %v, want %v", r.labels(nil), want)
+	}
+
+	// ab is set from aab and b from ab before the rule, whatever the order
+	// the labels are mapped in
+	for i := 0; i < 100; i++ {
+		values, keep := r.apply([]string{"1", "2"})
+		if want := []string{"1", "1", "2"}; !keep || !reflect.DeepEqual(values, want) {
+			t.Fatalf("apply = %v, %v, want %v", values, keep, want)
+		}
+	}
+}
@@ -0,0 +1,158 @@
+// Copyright 2019 Open Networking Foundation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"gerrit.opencord.org/kafka-topic-exporter/common/logger"
+	"github.com/Shopify/sarama"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// how often the lag of the claimed partitions is refreshed, whether messages
+// are consumed or not
+const lagRefreshInterval = 15 * time.Second
+
+// metrics of the exporter itself, the decode errors are in dead-letter.go
+var (
+	messagesConsumed = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "kafka_topic_exporter_messages_total",
+			Help: "Number of messages consumed from the partition",
+		},
+		[]string{"cluster", "topic", "partition"},
+	)
+	bytesConsumed = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "kafka_topic_exporter_bytes_total",
+			Help: "Number of message bytes consumed from the partition",
+		},
+		[]string{"cluster", "topic", "partition"},
+	)
+	exportDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "kafka_topic_exporter_export_duration_seconds",
+			Help:    "Time spent decoding a message and updating its metrics",
+			Buckets: []float64{.0001, .00025, .0005, .001, .0025, .005, .01, .025, .05, .1, .25},
+		},
+		[]string{"cluster", "topic"},
+	)
+	lastMessageTimestamp = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "kafka_topic_exporter_last_message_timestamp_seconds",
+			Help: "Timestamp of the last message consumed from the partition",
+		},
+		[]string{"cluster", "topic", "partition"},
+	)
+	partitionOffset = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "kafka_topic_exporter_offset",
+			Help: "Offset of the last message consumed from the partition",
+		},
+		[]string{"cluster", "topic", "partition"},
+	)
+	partitionHighWaterMark = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "kafka_topic_exporter_high_water_mark",
+			Help: "Offset of the next message to be published to the partition",
+		},
+		[]string{"cluster", "topic", "partition"},
+	)
+	consumerLag = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "kafka_topic_exporter_consumer_lag",
+			Help: "Number of messages of the partition published but not consumed yet",
+		},
+		[]string{"cluster", "topic", "partition"},
+	)
+)
+
+// observeMessage records a message consumed from a claim and the time
+// spent exporting it
+func observeMessage(info BrokerInfo, claim sarama.ConsumerGroupClaim, msg *sarama.ConsumerMessage, elapsed time.Duration) {
+	cluster := info.Name
+	partition := strconv.Itoa(int(msg.Partition))
+
+	messagesConsumed.WithLabelValues(cluster, msg.Topic, partition).Inc()
+	bytesConsumed.WithLabelValues(cluster, msg.Topic, partition).Add(float64(len(msg.Key) + len(msg.Value)))
+	exportDuration.WithLabelValues(cluster, msg.Topic).Observe(elapsed.Seconds())
+
+	// the message timestamp is not set by producers older than kafka 0.10
+	ts := msg.Timestamp
+	if ts.IsZero() {
+		ts = time.Now()
+	}
+	lastMessageTimestamp.WithLabelValues(cluster, msg.Topic, partition).Set(float64(ts.UnixNano()) / 1e9)
+	partitionOffset.WithLabelValues(cluster, msg.Topic, partition).Set(float64(msg.Offset))
+	setPartitionLag(info, msg.Topic, msg.Partition, claim.HighWaterMarkOffset(), msg.Offset+1)
+}
+
+// setPartitionLag records the high water mark of a partition and its lag
+// behind the next offset to consume
+func setPartitionLag(info BrokerInfo, topic string, p int32, highWaterMark int64, next int64) {
+	partition := strconv.Itoa(int(p))
+	partitionHighWaterMark.WithLabelValues(info.Name, topic, partition).Set(float64(highWaterMark))
+
+	// the high water mark is unknown until the first fetch response
+	lag := highWaterMark - next
+	if lag < 0 {
+		lag = 0
+	}
+	consumerLag.WithLabelValues(info.Name, topic, partition).Set(float64(lag))
+	connections.setLag(info.Name, topic, p, lag, info.MaxLag)
+}
+
+// refreshLag periodically sets the lag of a claimed partition from its newest
+// offset, until stop is closed, so that a partition whose consumption is
+// stalled is reported lagging behind. next is the offset of the next message
+// to consume, negative until known
+func refreshLag(stop <-chan struct{}, client sarama.Client, info BrokerInfo, claim sarama.ConsumerGroupClaim, next *int64) {
+	ticker := time.NewTicker(lagRefreshInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+		offset := atomic.LoadInt64(next)
+		if offset < 0 {
+			continue
+		}
+		newest, err := client.GetOffset(claim.Topic(), claim.Partition(), sarama.OffsetNewest)
+		if err != nil {
+			logger.Warn("Cannot get newest offset of %s[%d]: %s", claim.Topic(), claim.Partition(), err)
+			continue
+		}
+		setPartitionLag(info, claim.Topic(), claim.Partition(), newest, offset)
+	}
+}
+
+// forgetPartitions deletes the gauges of partitions no longer claimed, they
+// may now be consumed by another member of the group
+func forgetPartitions(cluster string, topic string, partitions []int32) {
+	for _, p := range partitions {
+		partition := strconv.Itoa(int(p))
+		lastMessageTimestamp.DeleteLabelValues(cluster, topic, partition)
+		partitionOffset.DeleteLabelValues(cluster, topic, partition)
+		partitionHighWaterMark.DeleteLabelValues(cluster, topic, partition)
+		consumerLag.DeleteLabelValues(cluster, topic, partition)
+		connections.setLag(cluster, topic, p, 0, 0)
+	}
+}
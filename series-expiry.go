@@ -0,0 +1,393 @@
+// Copyright 2019 Open Networking Foundation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"gerrit.opencord.org/kafka-topic-exporter/common/logger"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const defaultExpiryInterval = time.Minute
+
+var (
+	seriesExpired = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "kafka_topic_exporter_series_expired_total",
+			Help: "Number of series deleted because they were not updated within the TTL of their family",
+		},
+		[]string{"family"},
+	)
+
+	// metric families whose series may expire, by name, some are created
+	// once their first KPI is received
+	seriesTrackersMu sync.Mutex
+	seriesTrackers   = make(map[string]*seriesTracker)
+	expiry           ExpiryInfo
+)
+
+// seriesTracker records when the series of a metric family were last
+// updated, deletes the series not updated within the TTL of the family, and
+// bounds the number of series of the family
+type seriesTracker struct {
+	family string
+	// deletes a series, called under the lock so it must not admit series
+	delete func(labels []string)
+	// called once a series was deleted, if set
+	onExpire func(labels []string)
+
+	mu sync.Mutex
+	// 0 never expires
+	ttl time.Duration
+	// maximum number of series, 0 unlimited, and whether it was reported as
+	// reached
+	limit   int
+	limited bool
+	updated map[string]*trackedSeries
+}
+
+type trackedSeries struct {
+	labels []string
+	at     time.Time
+}
+
+func newSeriesTracker(family string, deleteSeries func(labels []string)) *seriesTracker {
+	t := &seriesTracker{
+		family:  family,
+		delete:  deleteSeries,
+		updated: make(map[string]*trackedSeries),
+	}
+
+	seriesTrackersMu.Lock()
+	defer seriesTrackersMu.Unlock()
+	t.ttl = familyTTL(family)
+	t.limit = familyLimit(family)
+	// a family name is registered once within Prometheus, the tracker of a
+	// family failing to register must not replace the registered one
+	if _, exists := seriesTrackers[family]; !exists {
+		seriesTrackers[family] = t
+	}
+	return t
+}
+
+func (t *seriesTracker) setTTL(ttl time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.ttl = ttl
+}
+
+func (t *seriesTracker) setLimit(limit int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.limit = limit
+}
+
+// admit records that a series was updated, it returns the labels of the
+// series to update, folded once a limit is reached, or false if the series
+// is dropped
+func (t *seriesTracker) admit(labels []string) ([]string, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.ttl == 0 && t.limit == 0 && limits.MaxTotalSeries == 0 {
+		return labels, true
+	}
+	key := strings.Join(labels, "\xff")
+	if s, ok := t.updated[key]; ok {
+		s.at = time.Now()
+		return labels, true
+	}
+
+	limit := ""
+	if t.limit > 0 && len(t.updated) >= t.limit {
+		limit = limitFamily
+		if !t.limited {
+			logger.Warn("[%s] reached its limit of %d series, overflow of new series: %s", t.family, t.limit, limits.Overflow)
+			t.limited = true
+		}
+	} else if !reserveSeries() {
+		limit = limitTotal
+	}
+	if limit != "" {
+		seriesLimited.WithLabelValues(t.family, limit).Inc()
+		if limits.Overflow != overflowFold {
+			return nil, false
+		}
+		// the overflow series itself is created whatever the limits
+		labels = overflowLabels(labels)
+		key = strings.Join(labels, "\xff")
+		if s, ok := t.updated[key]; ok {
+			s.at = time.Now()
+			return labels, true
+		}
+		atomic.AddInt64(&totalSeries, 1)
+	}
+
+	t.updated[key] = &trackedSeries{labels: append([]string(nil), labels...), at: time.Now()}
+	seriesCount.WithLabelValues(t.family).Set(float64(len(t.updated)))
+	return labels, true
+}
+
+// expire deletes the series not updated since ttl before now
+func (t *seriesTracker) expire(now time.Time) {
+	// the series are deleted under the lock, otherwise a series admitted
+	// again meanwhile would be tracked but deleted
+	t.mu.Lock()
+	var expired [][]string
+	for key, s := range t.updated {
+		if t.ttl > 0 && now.Sub(s.at) > t.ttl {
+			expired = append(expired, s.labels)
+			delete(t.updated, key)
+			t.delete(s.labels)
+		}
+	}
+	if len(expired) > 0 {
+		seriesCount.WithLabelValues(t.family).Set(float64(len(t.updated)))
+		releaseSeries(len(expired))
+		if t.limited && len(t.updated) < t.limit {
+			logger.Info("[%s] is back under its limit of %d series", t.family, t.limit)
+			t.limited = false
+		}
+	}
+	t.mu.Unlock()
+
+	// onExpire may update other series of the family
+	for _, labels := range expired {
+		if t.onExpire != nil {
+			t.onExpire(labels)
+		}
+	}
+	if len(expired) > 0 {
+		logger.Debug("Expired %d series of %s", len(expired), t.family)
+		seriesExpired.WithLabelValues(t.family).Add(float64(len(expired)))
+	}
+}
+
+// gaugeVec is a GaugeVec whose series may expire, may be exported with the
+// timestamp of their KPI, are relabeled and limited
+type gaugeVec struct {
+	*prometheus.GaugeVec
+	opts       prometheus.GaugeOpts
+	tracker    *seriesTracker
+	times      *sampleTimes
+	relabeling *relabeling
+}
+
+func newGaugeVec(opts prometheus.GaugeOpts, labels []string) *gaugeVec {
+	v := &gaugeVec{
+		GaugeVec: prometheus.NewGaugeVec(opts, labels),
+		opts:     opts,
+		times:    newSampleTimes(labels),
+	}
+	v.tracker = newSeriesTracker(opts.Name, func(labels []string) {
+		v.GaugeVec.DeleteLabelValues(labels...)
+		v.times.forget(labels)
+	})
+	registerRelabelable(opts.Name, labels, v)
+	return v
+}
+
+func (v *gaugeVec) relabel(r *relabeling) {
+	if r == nil {
+		return
+	}
+	v.relabeling = r
+	v.GaugeVec = prometheus.NewGaugeVec(v.opts, r.labels(nil))
+	v.times = newSampleTimes(r.labels(nil))
+}
+
+func (v *gaugeVec) WithLabelValues(labels ...string) prometheus.Gauge {
+	return v.with(time.Time{}, labels)
+}
+
+func (v *gaugeVec) with(ts time.Time, labels []string) prometheus.Gauge {
+	labels, keep := v.relabeling.apply(labels)
+	if keep {
+		labels, keep = v.tracker.admit(labels)
+	}
+	if !keep {
+		return discardedGauge
+	}
+	v.times.stamp(ts, labels)
+	return v.GaugeVec.WithLabelValues(labels...)
+}
+
+func (v *gaugeVec) Collect(ch chan<- prometheus.Metric) {
+	v.times.collect(v.GaugeVec, ch)
+}
+
+// at returns the family stamping the series it updates with ts, the time
+// of their KPI
+func (v *gaugeVec) at(ts time.Time) stampedGaugeVec {
+	return stampedGaugeVec{v, ts}
+}
+
+type stampedGaugeVec struct {
+	vec *gaugeVec
+	ts  time.Time
+}
+
+func (s stampedGaugeVec) WithLabelValues(labels ...string) prometheus.Gauge {
+	return s.vec.with(s.ts, labels)
+}
+
+// counterVec is a CounterVec whose series may expire, may be exported with
+// the timestamp of their KPI, are relabeled and limited
+type counterVec struct {
+	*prometheus.CounterVec
+	opts       prometheus.CounterOpts
+	tracker    *seriesTracker
+	times      *sampleTimes
+	relabeling *relabeling
+}
+
+func newCounterVec(opts prometheus.CounterOpts, labels []string) *counterVec {
+	v := &counterVec{
+		CounterVec: prometheus.NewCounterVec(opts, labels),
+		opts:       opts,
+		times:      newSampleTimes(labels),
+	}
+	v.tracker = newSeriesTracker(opts.Name, func(labels []string) {
+		v.CounterVec.DeleteLabelValues(labels...)
+		v.times.forget(labels)
+	})
+	registerRelabelable(opts.Name, labels, v)
+	return v
+}
+
+func (v *counterVec) relabel(r *relabeling) {
+	if r == nil {
+		return
+	}
+	v.relabeling = r
+	v.CounterVec = prometheus.NewCounterVec(v.opts, r.labels(nil))
+	v.times = newSampleTimes(r.labels(nil))
+}
+
+func (v *counterVec) WithLabelValues(labels ...string) prometheus.Counter {
+	return v.with(time.Time{}, labels)
+}
+
+func (v *counterVec) with(ts time.Time, labels []string) prometheus.Counter {
+	labels, keep := v.relabeling.apply(labels)
+	if keep {
+		labels, keep = v.tracker.admit(labels)
+	}
+	if !keep {
+		return discardedCounter
+	}
+	v.times.stamp(ts, labels)
+	return v.CounterVec.WithLabelValues(labels...)
+}
+
+// restore adds a saved value to a series
+func (v *counterVec) restore(labels []string, value float64) error {
+	labels, keep := v.relabeling.apply(labels)
+	if keep {
+		labels, keep = v.tracker.admit(labels)
+	}
+	if !keep {
+		return nil
+	}
+	counter, err := v.CounterVec.GetMetricWithLabelValues(labels...)
+	if err != nil {
+		return err
+	}
+	counter.Add(value)
+	return nil
+}
+
+func (v *counterVec) Collect(ch chan<- prometheus.Metric) {
+	v.times.collect(v.CounterVec, ch)
+}
+
+// at returns the family stamping the series it updates with ts, the time
+// of their KPI
+func (v *counterVec) at(ts time.Time) stampedCounterVec {
+	return stampedCounterVec{v, ts}
+}
+
+type stampedCounterVec struct {
+	vec *counterVec
+	ts  time.Time
+}
+
+func (s stampedCounterVec) WithLabelValues(labels ...string) prometheus.Counter {
+	return s.vec.with(s.ts, labels)
+}
+
+// configureExpiry sets the TTL of the metric families, families created
+// later get theirs when created
+func configureExpiry(info ExpiryInfo) {
+	seriesTrackersMu.Lock()
+	defer seriesTrackersMu.Unlock()
+
+	expiry = info
+	for family, t := range seriesTrackers {
+		t.setTTL(familyTTL(family))
+	}
+}
+
+// familyTTL returns the configured TTL of a family
+func familyTTL(family string) time.Duration {
+	if value, ok := expiry.TTL[family]; ok {
+		return parseTTL(value, family)
+	}
+	return parseTTL(expiry.DefaultTTL, "default")
+}
+
+// parseTTL returns 0, i.e. never expire, for empty or invalid TTLs
+func parseTTL(value string, family string) time.Duration {
+	if value == "" {
+		return 0
+	}
+	ttl, err := time.ParseDuration(value)
+	if err != nil || ttl < 0 {
+		logger.Error("Invalid ttl [%s] for [%s], series won't expire", value, family)
+		return 0
+	}
+	return ttl
+}
+
+// runExpiry deletes the expired series periodically until the context is
+// cancelled
+func runExpiry(ctx context.Context, info ExpiryInfo) {
+	interval := defaultExpiryInterval
+	if info.Interval != "" {
+		var err error
+		if interval, err = time.ParseDuration(info.Interval); err != nil || interval <= 0 {
+			logger.Error("Invalid expiry interval [%s], using default %s", info.Interval, defaultExpiryInterval)
+			interval = defaultExpiryInterval
+		}
+	}
+	for wait(ctx, interval) {
+		seriesTrackersMu.Lock()
+		trackers := make([]*seriesTracker, 0, len(seriesTrackers))
+		for _, t := range seriesTrackers {
+			trackers = append(trackers, t)
+		}
+		seriesTrackersMu.Unlock()
+
+		now := time.Now()
+		for _, t := range trackers {
+			t.expire(now)
+		}
+	}
+}
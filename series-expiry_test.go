@@ -0,0 +1,77 @@
+// Copyright 2019 Open Networking Foundation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+)
+
+// removeTracker unregisters the tracker of a test family, so that it is
+// neither expired nor configured by other tests
+func removeTracker(family string) {
+	seriesTrackersMu.Lock()
+	defer seriesTrackersMu.Unlock()
+	if t, ok := seriesTrackers[family]; ok {
+		t.mu.Lock()
+		releaseSeries(len(t.updated))
+		t.mu.Unlock()
+		delete(seriesTrackers, family)
+	}
+}
+
+func TestExpireDeletesBeforeAdmit(t *testing.T) {
+	defer removeTracker("test_expire_admit")
+
+	var mu sync.Mutex
+	var events []string
+	record := func(event string) {
+		mu.Lock()
+		defer mu.Unlock()
+		events = append(events, event)
+	}
+
+	var tracker *seriesTracker
+	admitting := make(chan struct{})
+	admitted := make(chan struct{})
+	tracker = newSeriesTracker("test_expire_admit", func(labels []string) {
+		// the series is admitted again while it is deleted
+		go func() {
+			close(admitting)
+			tracker.admit(labels)
+			record("admitted")
+			close(admitted)
+		}()
+		<-admitting
+		runtime.Gosched()
+		record("deleted")
+	})
+	tracker.setTTL(time.Minute)
+
+	tracker.admit([]string{"cluster", "1"})
+	tracker.expire(time.Now().Add(time.Hour))
+	<-admitted
+
+	if len(events) != 2 || events[0] != "deleted" {
+		t.Errorf("events %v, the series must be deleted before it is admitted again", events)
+	}
+	tracker.mu.Lock()
+	defer tracker.mu.Unlock()
+	if len(tracker.updated) != 1 {
+		t.Errorf("%d series tracked, want the admitted one", len(tracker.updated))
+	}
+}
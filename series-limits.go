@@ -0,0 +1,117 @@
+// Copyright 2019 Open Networking Foundation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"sync/atomic"
+
+	"gerrit.opencord.org/kafka-topic-exporter/common/logger"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	// new series are not created once a limit is reached
+	overflowDrop = "drop"
+	// new series are folded into a single series whose label values are
+	// overflowLabelValue once a limit is reached
+	overflowFold       = "fold"
+	overflowLabelValue = "__overflow__"
+
+	limitFamily = "family"
+	limitTotal  = "total"
+)
+
+var (
+	seriesCount = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "kafka_topic_exporter_series",
+			Help: "Number of series of the families whose series expire or are limited",
+		},
+		[]string{"family"},
+	)
+	seriesLimited = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "kafka_topic_exporter_series_limited_total",
+			Help: "Number of new series dropped or folded because a series limit was reached",
+		},
+		[]string{"family", "limit"},
+	)
+
+	// set by configureLimits, before any series is created
+	limits LimitsInfo
+	// series of all the limited families, and whether the total limit was
+	// reported as reached
+	totalSeries  int64
+	totalLimited int32
+)
+
+// configureLimits sets the series limits of the metric families, families
+// created later get theirs when created
+func configureLimits(info LimitsInfo) {
+	seriesTrackersMu.Lock()
+	defer seriesTrackersMu.Unlock()
+
+	switch info.Overflow {
+	case "":
+		info.Overflow = overflowDrop
+	case overflowDrop, overflowFold:
+	default:
+		logger.Error("Invalid overflow [%s], dropping the series over the limits", info.Overflow)
+		info.Overflow = overflowDrop
+	}
+	limits = info
+	for family, t := range seriesTrackers {
+		t.setLimit(familyLimit(family))
+	}
+}
+
+// familyLimit returns the configured series limit of a family, 0 unlimited
+func familyLimit(family string) int {
+	if limit, ok := limits.MaxSeries[family]; ok {
+		return limit
+	}
+	return limits.DefaultMaxSeries
+}
+
+// reserveSeries counts a new series against the total limit, it returns
+// false once the limit is reached
+func reserveSeries() bool {
+	n := atomic.AddInt64(&totalSeries, 1)
+	if limits.MaxTotalSeries > 0 && n > int64(limits.MaxTotalSeries) {
+		atomic.AddInt64(&totalSeries, -1)
+		if atomic.CompareAndSwapInt32(&totalLimited, 0, 1) {
+			logger.Warn("Reached the limit of %d series, overflow of new series: %s", limits.MaxTotalSeries, limits.Overflow)
+		}
+		return false
+	}
+	return true
+}
+
+// releaseSeries counts deleted series
+func releaseSeries(n int) {
+	left := atomic.AddInt64(&totalSeries, -int64(n))
+	if left < int64(limits.MaxTotalSeries) && atomic.CompareAndSwapInt32(&totalLimited, 1, 0) {
+		logger.Info("Back under the limit of %d series", limits.MaxTotalSeries)
+	}
+}
+
+// overflowLabels returns the labels of the series new series are folded into
+func overflowLabels(labels []string) []string {
+	folded := make([]string, len(labels))
+	for i := range folded {
+		folded[i] = overflowLabelValue
+	}
+	return folded
+}
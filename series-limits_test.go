@@ -0,0 +1,164 @@
+// Copyright 2019 Open Networking Foundation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"reflect"
+	"sort"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// newLimitedGauge returns a gauge of a test family, and a function setting
+// a series by device
+func newLimitedGauge(family string) (*gaugeVec, func(device string)) {
+	v := newGaugeVec(prometheus.GaugeOpts{Name: family, Help: "test"}, []string{"cluster", "device_id"})
+	return v, func(device string) { v.WithLabelValues("test", device).Set(1) }
+}
+
+// gatheredDevices returns the sorted devices of the gathered series
+func gatheredDevices(t *testing.T, v *gaugeVec) []string {
+	var devices []string
+	for _, m := range gather(t, v) {
+		devices = append(devices, labelValue(m, "device_id"))
+	}
+	sort.Strings(devices)
+	return devices
+}
+
+// seriesOf returns the value of the series of a self metric whose labels
+// have the values, 0 if none
+func seriesOf(t *testing.T, c prometheus.Collector, values ...string) float64 {
+	for _, m := range gather(t, c) {
+		if len(m.Label) != len(values) {
+			continue
+		}
+		match := true
+		for i, label := range m.Label {
+			match = match && label.GetValue() == values[i]
+		}
+		if match {
+			return metricValue(m)
+		}
+	}
+	return 0
+}
+
+func metricValue(m *dto.Metric) float64 {
+	if m.Gauge != nil {
+		return m.Gauge.GetValue()
+	}
+	return m.Counter.GetValue()
+}
+
+func TestFamilyLimit(t *testing.T) {
+	defer configureLimits(LimitsInfo{})
+
+	for _, c := range []struct {
+		overflow string
+		want     []string
+	}{
+		{overflowDrop, []string{"olt-1", "olt-2"}},
+		{overflowFold, []string{overflowLabelValue, "olt-1", "olt-2"}},
+	} {
+		family := "test_limit_" + c.overflow
+		configureLimits(LimitsInfo{MaxSeries: map[string]int{family: 2}, Overflow: c.overflow})
+		before := seriesOf(t, seriesLimited, family, limitFamily)
+		v, set := newLimitedGauge(family)
+		for _, device := range []string{"olt-1", "olt-2", "olt-3", "olt-4", "olt-1"} {
+			set(device)
+		}
+
+		if devices := gatheredDevices(t, v); !reflect.DeepEqual(devices, c.want) {
+			t.Errorf("%s: gathered devices %v, want %v", c.overflow, devices, c.want)
+		}
+		if count := seriesOf(t, seriesCount, family); count != float64(len(c.want)) {
+			t.Errorf("%s: %v series counted, want %d", c.overflow, count, len(c.want))
+		}
+		if limited := seriesOf(t, seriesLimited, family, limitFamily) - before; limited != 2 {
+			t.Errorf("%s: %v series limited, want 2", c.overflow, limited)
+		}
+		removeTracker(family)
+	}
+}
+
+func TestTotalLimit(t *testing.T) {
+	defer configureLimits(LimitsInfo{})
+	defer removeTracker("test_total_limit_1")
+	defer removeTracker("test_total_limit_2")
+
+	// the series of the other tests are counted as well
+	configureLimits(LimitsInfo{MaxTotalSeries: int(atomic.LoadInt64(&totalSeries)) + 3})
+	before1 := seriesOf(t, seriesLimited, "test_total_limit_1", limitTotal)
+	before2 := seriesOf(t, seriesLimited, "test_total_limit_2", limitTotal)
+	v1, set1 := newLimitedGauge("test_total_limit_1")
+	v2, set2 := newLimitedGauge("test_total_limit_2")
+	set1("olt-1")
+	set2("olt-1")
+	set1("olt-2")
+	set2("olt-2")
+	set1("olt-3")
+
+	if devices := gatheredDevices(t, v1); !reflect.DeepEqual(devices, []string{"olt-1", "olt-2"}) {
+		t.Errorf("gathered devices %v of the first family, want olt-1 and olt-2", devices)
+	}
+	if devices := gatheredDevices(t, v2); !reflect.DeepEqual(devices, []string{"olt-1"}) {
+		t.Errorf("gathered devices %v of the second family, want olt-1", devices)
+	}
+	if limited := seriesOf(t, seriesLimited, "test_total_limit_1", limitTotal) - before1; limited != 1 {
+		t.Errorf("%v series of the first family limited, want 1", limited)
+	}
+	if limited := seriesOf(t, seriesLimited, "test_total_limit_2", limitTotal) - before2; limited != 1 {
+		t.Errorf("%v series of the second family limited, want 1", limited)
+	}
+}
+
+func TestExpiryReleasesSeries(t *testing.T) {
+	defer configureLimits(LimitsInfo{})
+	defer removeTracker("test_limit_expiry")
+
+	configureLimits(LimitsInfo{MaxSeries: map[string]int{"test_limit_expiry": 1}})
+	v, set := newLimitedGauge("test_limit_expiry")
+	v.tracker.setTTL(time.Minute)
+	total := atomic.LoadInt64(&totalSeries)
+	before := seriesOf(t, seriesLimited, "test_limit_expiry", limitFamily)
+
+	set("olt-1")
+	set("olt-2")
+	if devices := gatheredDevices(t, v); !reflect.DeepEqual(devices, []string{"olt-1"}) {
+		t.Errorf("gathered devices %v, want olt-1", devices)
+	}
+
+	v.tracker.expire(time.Now().Add(time.Hour))
+	if n := atomic.LoadInt64(&totalSeries); n != total {
+		t.Errorf("%d series counted in total after expiry, want %d", n, total)
+	}
+	if count := seriesOf(t, seriesCount, "test_limit_expiry"); count != 0 {
+		t.Errorf("%v series counted after expiry, want 0", count)
+	}
+
+	// the expired series made room for a new one
+	set("olt-2")
+	if devices := gatheredDevices(t, v); !reflect.DeepEqual(devices, []string{"olt-2"}) {
+		t.Errorf("gathered devices %v, want olt-2", devices)
+	}
+	if limited := seriesOf(t, seriesLimited, "test_limit_expiry", limitFamily) - before; limited != 1 {
+		t.Errorf("%v series limited, want 1", limited)
+	}
+}
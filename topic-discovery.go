@@ -0,0 +1,155 @@
+// Copyright 2019 Open Networking Foundation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"gerrit.opencord.org/kafka-topic-exporter/common/logger"
+	"github.com/Shopify/sarama"
+)
+
+const defaultDiscoveryInterval = time.Minute
+
+// topicPattern is a topic entry of the configuration matching topics by
+// regular expression instead of by name
+type topicPattern struct {
+	info   TopicInfo
+	regexp *regexp.Regexp
+}
+
+// topicDiscovery periodically matches the topics of the cluster against the
+// topic patterns, it subscribes the group consumer to every new matching
+// topic and unsubscribes it once the topic is deleted
+type topicDiscovery struct {
+	broker   BrokerInfo
+	config   *sarama.Config
+	consumer *groupConsumer
+	patterns []topicPattern
+	// topics configured by name, they are never discovered
+	static map[string]bool
+	// discovered topics the consumer is subscribed to
+	discovered map[string]bool
+}
+
+func newTopicDiscovery(broker BrokerInfo, config *sarama.Config, consumer *groupConsumer,
+	patterns []topicPattern, static map[string]bool) *topicDiscovery {
+	return &topicDiscovery{
+		broker:     broker,
+		config:     config,
+		consumer:   consumer,
+		patterns:   patterns,
+		static:     static,
+		discovered: make(map[string]bool),
+	}
+}
+
+func (d *topicDiscovery) run(ctx context.Context, wg *sync.WaitGroup) {
+	defer wg.Done()
+
+	interval := defaultDiscoveryInterval
+	if d.broker.DiscoveryInterval != "" {
+		var err error
+		if interval, err = time.ParseDuration(d.broker.DiscoveryInterval); err != nil || interval <= 0 {
+			logger.Error("Invalid discovery_interval [%s] for [%s], using default %s",
+				d.broker.DiscoveryInterval, d.broker.Name, defaultDiscoveryInterval)
+			interval = defaultDiscoveryInterval
+		}
+	}
+
+	var client sarama.Client
+	defer func() {
+		if client != nil {
+			client.Close()
+		}
+	}()
+
+	for {
+		if client == nil {
+			var err error
+			if client, err = sarama.NewClient(d.broker.bootstrapHosts(), d.config); err != nil {
+				logger.Error("Topic discovery on [%s] cannot connect: %s", d.broker.Name, err)
+				client = nil
+			}
+		}
+		if client != nil {
+			if err := d.discover(client); err != nil {
+				logger.Error("Topic discovery on [%s] failed: %s", d.broker.Name, err)
+			}
+		}
+		if !wait(ctx, interval) {
+			return
+		}
+	}
+}
+
+// discover reconciles the subscription of the consumer with the topics of
+// the cluster
+func (d *topicDiscovery) discover(client sarama.Client) error {
+	if err := client.RefreshMetadata(); err != nil {
+		return err
+	}
+	topics, err := client.Topics()
+	if err != nil {
+		return err
+	}
+
+	found := make(map[string]bool)
+	for _, name := range topics {
+		pattern, ok := d.match(name)
+		if !ok {
+			continue
+		}
+		found[name] = true
+		if d.discovered[name] {
+			continue
+		}
+
+		topic := pattern.info
+		topic.Name = name
+		topic.Pattern = ""
+		logger.Info("Discovered topic [%s] on [%s] matching [%s]", name, d.broker.Name, pattern.info.Pattern)
+		d.discovered[name] = true
+		d.consumer.addTopic(topic)
+	}
+
+	for name := range d.discovered {
+		if !found[name] {
+			logger.Info("Topic [%s] on [%s] is gone, no longer consuming it", name, d.broker.Name)
+			d.consumer.removeTopic(name)
+			delete(d.discovered, name)
+		}
+	}
+	return nil
+}
+
+// match returns the first pattern matching a topic which can be discovered
+func (d *topicDiscovery) match(name string) (topicPattern, bool) {
+	// skip kafka internal topics, e.g. __consumer_offsets, and the dead
+	// letters, they would be consumed and forwarded to themselves again
+	if d.static[name] || strings.HasPrefix(name, "__") || name == d.broker.DeadLetterTopic {
+		return topicPattern{}, false
+	}
+	for _, pattern := range d.patterns {
+		if pattern.regexp.MatchString(name) {
+			return pattern, true
+		}
+	}
+	return topicPattern{}, false
+}
@@ -0,0 +1,38 @@
+// Copyright 2019 Open Networking Foundation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"regexp"
+	"testing"
+)
+
+func TestDiscoverySkipsDeadLetters(t *testing.T) {
+	pattern := topicPattern{info: TopicInfo{Pattern: "voltha.*"}, regexp: regexp.MustCompile("voltha.*")}
+	d := newTopicDiscovery(BrokerInfo{Name: "voltha", DeadLetterTopic: "voltha.dlq"}, nil, nil,
+		[]topicPattern{pattern}, map[string]bool{"voltha.events": true})
+
+	for name, want := range map[string]bool{
+		"voltha.kpis":        true,
+		"voltha.dlq":         false,
+		"voltha.events":      false,
+		"__consumer_offsets": false,
+		"onos.kpis":          false,
+	} {
+		if _, ok := d.match(name); ok != want {
+			t.Errorf("match(%s) = %v, want %v", name, ok, want)
+		}
+	}
+}
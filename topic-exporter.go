@@ -16,173 +16,726 @@ package main
 
 import (
 	"encoding/json"
-	"log"
+	"errors"
+	"fmt"
+	"time"
+
 	"github.com/prometheus/client_golang/prometheus"
-	"gerrit.opencord.org/kafka-topic-exporter/common/logger"
 )
 
 var (
 	// voltha kpis
-	volthaTxBytesTotal = prometheus.NewGaugeVec(
+	volthaTxBytesTotal = newGaugeVec(
 		prometheus.GaugeOpts{
 			Name: "voltha_tx_bytes_total",
 			Help: "Number of total bytes transmitted",
 		},
-		[]string{"logical_device_id", "serial_number", "device_id", "interface_id", "pon_id", "port_number", "title"},
+		[]string{"cluster", "logical_device_id", "serial_number", "device_id", "interface_id", "pon_id", "port_number", "title"},
 	)
-	volthaRxBytesTotal = prometheus.NewGaugeVec(
+	volthaRxBytesTotal = newGaugeVec(
 		prometheus.GaugeOpts{
 			Name: "voltha_rx_bytes_total",
 			Help: "Number of total bytes received",
 		},
-		[]string{"logical_device_id", "serial_number", "device_id", "interface_id", "pon_id", "port_number", "title"},
+		[]string{"cluster", "logical_device_id", "serial_number", "device_id", "interface_id", "pon_id", "port_number", "title"},
 	)
-	volthaTxPacketsTotal = prometheus.NewGaugeVec(
+	volthaTxPacketsTotal = newGaugeVec(
 		prometheus.GaugeOpts{
 			Name: "voltha_tx_packets_total",
 			Help: "Number of total packets transmitted",
 		},
-		[]string{"logical_device_id", "serial_number", "device_id", "interface_id", "pon_id", "port_number", "title"},
+		[]string{"cluster", "logical_device_id", "serial_number", "device_id", "interface_id", "pon_id", "port_number", "title"},
 	)
-	volthaRxPacketsTotal = prometheus.NewGaugeVec(
+	volthaRxPacketsTotal = newGaugeVec(
 		prometheus.GaugeOpts{
 			Name: "voltha_rx_packets_total",
 			Help: "Number of total packets received",
 		},
-		[]string{"logical_device_id", "serial_number", "device_id", "interface_id", "pon_id", "port_number", "title"},
+		[]string{"cluster", "logical_device_id", "serial_number", "device_id", "interface_id", "pon_id", "port_number", "title"},
 	)
 
-	volthaTxErrorPacketsTotal = prometheus.NewGaugeVec(
+	volthaTxErrorPacketsTotal = newGaugeVec(
 		prometheus.GaugeOpts{
 			Name: "voltha_tx_error_packets_total",
 			Help: "Number of total transmitted packets error",
 		},
-		[]string{"logical_device_id", "serial_number", "device_id", "interface_id", "pon_id", "port_number", "title"},
+		[]string{"cluster", "logical_device_id", "serial_number", "device_id", "interface_id", "pon_id", "port_number", "title"},
 	)
 
-	volthaRxErrorPacketsTotal = prometheus.NewGaugeVec(
+	volthaRxErrorPacketsTotal = newGaugeVec(
 		prometheus.GaugeOpts{
 			Name: "voltha_rx_error_packets_total",
 			Help: "Number of total received packets error",
 		},
-		[]string{"logical_device_id", "serial_number", "device_id", "interface_id", "pon_id", "port_number", "title"},
+		[]string{"cluster", "logical_device_id", "serial_number", "device_id", "interface_id", "pon_id", "port_number", "title"},
+	)
+
+	volthaTxBcastPacketsTotal = newGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "voltha_tx_bcast_packets_total",
+			Help: "Number of total broadcast packets transmitted",
+		},
+		[]string{"cluster", "logical_device_id", "serial_number", "device_id", "interface_id", "pon_id", "port_number", "title"},
+	)
+
+	volthaTxUcastPacketsTotal = newGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "voltha_tx_ucast_packets_total",
+			Help: "Number of total unicast packets transmitted",
+		},
+		[]string{"cluster", "logical_device_id", "serial_number", "device_id", "interface_id", "pon_id", "port_number", "title"},
+	)
+
+	volthaTxMcastPacketsTotal = newGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "voltha_tx_mcast_packets_total",
+			Help: "Number of total multicast packets transmitted",
+		},
+		[]string{"cluster", "logical_device_id", "serial_number", "device_id", "interface_id", "pon_id", "port_number", "title"},
+	)
+
+	volthaRxBcastPacketsTotal = newGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "voltha_rx_bcast_packets_total",
+			Help: "Number of total broadcast packets received",
+		},
+		[]string{"cluster", "logical_device_id", "serial_number", "device_id", "interface_id", "pon_id", "port_number", "title"},
+	)
+
+	volthaRxUcastPacketsTotal = newGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "voltha_rx_ucast_packets_total",
+			Help: "Number of total unicast packets received",
+		},
+		[]string{"cluster", "logical_device_id", "serial_number", "device_id", "interface_id", "pon_id", "port_number", "title"},
+	)
+
+	volthaRxMcastPacketsTotal = newGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "voltha_rx_mcast_packets_total",
+			Help: "Number of total multicast packets received",
+		},
+		[]string{"cluster", "logical_device_id", "serial_number", "device_id", "interface_id", "pon_id", "port_number", "title"},
+	)
+
+	volthaLastKpiTimestamp = newGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "voltha_last_kpi_timestamp_seconds",
+			Help: "Timestamp of the last KPI of the device",
+		},
+		[]string{"cluster", "logical_device_id", "serial_number", "device_id"},
+	)
+	volthaKpiTimestampSkew = newGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "voltha_kpi_timestamp_skew_seconds",
+			Help: "Time between the last KPI of the device and its publication to kafka",
+		},
+		[]string{"cluster", "logical_device_id", "serial_number", "device_id"},
+	)
+
+	// voltha onu optical levels
+	volthaOnuOpticalTxPowerDbm = newGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "voltha_onu_optical_tx_power_dbm",
+			Help: "Optical power transmitted by the ONU, in dBm",
+		},
+		[]string{"cluster", "logical_device_id", "serial_number", "device_id", "pon_id"},
+	)
+	volthaOnuOpticalRxPowerDbm = newGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "voltha_onu_optical_rx_power_dbm",
+			Help: "Optical power received by the ONU, in dBm",
+		},
+		[]string{"cluster", "logical_device_id", "serial_number", "device_id", "pon_id"},
+	)
+	volthaOnuLaserBiasCurrentMilliamperes = newGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "voltha_onu_laser_bias_current_milliamperes",
+			Help: "Laser bias current of the ONU transceiver",
+		},
+		[]string{"cluster", "logical_device_id", "serial_number", "device_id", "pon_id"},
+	)
+	volthaOnuTransceiverVoltageVolts = newGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "voltha_onu_transceiver_voltage_volts",
+			Help: "Supply voltage of the ONU transceiver",
+		},
+		[]string{"cluster", "logical_device_id", "serial_number", "device_id", "pon_id"},
+	)
+	volthaOnuTransceiverTemperatureCelsius = newGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "voltha_onu_transceiver_temperature_celsius",
+			Help: "Temperature of the ONU transceiver",
+		},
+		[]string{"cluster", "logical_device_id", "serial_number", "device_id", "pon_id"},
+	)
+
+	// voltha olt optical levels, per pon port
+	volthaOltPonOpticalTxPowerDbm = newGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "voltha_olt_pon_optical_tx_power_dbm",
+			Help: "Optical power transmitted by the OLT PON port, in dBm",
+		},
+		[]string{"cluster", "logical_device_id", "serial_number", "device_id", "interface_id", "pon_id", "port_number"},
+	)
+	volthaOltPonOpticalRxPowerDbm = newGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "voltha_olt_pon_optical_rx_power_dbm",
+			Help: "Optical power received by the OLT PON port, in dBm",
+		},
+		[]string{"cluster", "logical_device_id", "serial_number", "device_id", "interface_id", "pon_id", "port_number"},
+	)
+	volthaOltPonLaserBiasCurrentMilliamperes = newGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "voltha_olt_pon_laser_bias_current_milliamperes",
+			Help: "Laser bias current of the OLT PON port transceiver",
+		},
+		[]string{"cluster", "logical_device_id", "serial_number", "device_id", "interface_id", "pon_id", "port_number"},
+	)
+	volthaOltPonTransceiverVoltageVolts = newGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "voltha_olt_pon_transceiver_voltage_volts",
+			Help: "Supply voltage of the OLT PON port transceiver",
+		},
+		[]string{"cluster", "logical_device_id", "serial_number", "device_id", "interface_id", "pon_id", "port_number"},
+	)
+	volthaOltPonTransceiverTemperatureCelsius = newGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "voltha_olt_pon_transceiver_temperature_celsius",
+			Help: "Temperature of the OLT PON port transceiver",
+		},
+		[]string{"cluster", "logical_device_id", "serial_number", "device_id", "interface_id", "pon_id", "port_number"},
+	)
+
+	// voltha onu pm history, accumulated from the intervals
+	volthaOnuBridgePortPacketsTotal = newCounterVec(
+		prometheus.CounterOpts{
+			Name: "voltha_onu_bridge_port_packets_total",
+			Help: "Number of total packets through the ONU bridge port",
+		},
+		[]string{"cluster", "logical_device_id", "serial_number", "device_id", "parent_class_id", "parent_entity_id", "direction"},
+	)
+	volthaOnuBridgePortBytesTotal = newCounterVec(
+		prometheus.CounterOpts{
+			Name: "voltha_onu_bridge_port_bytes_total",
+			Help: "Number of total bytes through the ONU bridge port",
+		},
+		[]string{"cluster", "logical_device_id", "serial_number", "device_id", "parent_class_id", "parent_entity_id", "direction"},
+	)
+
+	volthaOnuBridgePortHistory = newPMHistory("Ethernet_Bridge_Port_History",
+		func(cluster string, data *SliceData) []string {
+			direction := "downstream"
+			if data.Metadata.Context.Upstream == "True" {
+				direction = "upstream"
+			}
+			return []string{
+				cluster,
+				data.Metadata.LogicalDeviceID,
+				data.Metadata.SerialNumber,
+				data.Metadata.DeviceID,
+				data.Metadata.Context.ParentClassId,
+				data.Metadata.Context.ParentEntityId,
+				direction,
+			}
+		},
+		map[string]pmCounter{
+			"packets": {volthaOnuBridgePortPacketsTotal, func(m *Metrics) float64 { return m.Packets }},
+			"octets":  {volthaOnuBridgePortBytesTotal, func(m *Metrics) float64 { return m.Octets }},
+		},
 	)
 
 	// onos kpis
-	onosTxBytesTotal = prometheus.NewGaugeVec(
+	onosTxBytesTotal = newGaugeVec(
 		prometheus.GaugeOpts{
 			Name: "onos_tx_bytes_total",
 			Help: "Number of total bytes transmitted",
 		},
-		[]string{"device_id", "port_id"},
+		[]string{"cluster", "device_id", "port_id"},
 	)
-	onosRxBytesTotal = prometheus.NewGaugeVec(
+	onosRxBytesTotal = newGaugeVec(
 		prometheus.GaugeOpts{
 			Name: "onos_rx_bytes_total",
 			Help: "Number of total bytes received",
 		},
-		[]string{"device_id", "port_id"},
+		[]string{"cluster", "device_id", "port_id"},
 	)
-	onosTxPacketsTotal = prometheus.NewGaugeVec(
+	onosTxPacketsTotal = newGaugeVec(
 		prometheus.GaugeOpts{
 			Name: "onos_tx_packets_total",
 			Help: "Number of total packets transmitted",
 		},
-		[]string{"device_id", "port_id"},
+		[]string{"cluster", "device_id", "port_id"},
 	)
-	onosRxPacketsTotal = prometheus.NewGaugeVec(
+	onosRxPacketsTotal = newGaugeVec(
 		prometheus.GaugeOpts{
 			Name: "onos_rx_packets_total",
 			Help: "Number of total packets received",
 		},
-		[]string{"device_id", "port_id"},
+		[]string{"cluster", "device_id", "port_id"},
 	)
 
-	onosTxDropPacketsTotal = prometheus.NewGaugeVec(
+	onosTxDropPacketsTotal = newGaugeVec(
 		prometheus.GaugeOpts{
 			Name: "onos_tx_drop_packets_total",
 			Help: "Number of total transmitted packets dropped",
 		},
-		[]string{"device_id", "port_id"},
+		[]string{"cluster", "device_id", "port_id"},
 	)
 
-	onosRxDropPacketsTotal = prometheus.NewGaugeVec(
+	onosRxDropPacketsTotal = newGaugeVec(
 		prometheus.GaugeOpts{
 			Name: "onos_rx_drop_packets_total",
 			Help: "Number of total received packets dropped",
 		},
-		[]string{"device_id", "port_id"},
+		[]string{"cluster", "device_id", "port_id"},
+	)
+
+	volthaOnuFecCorrectedBytesTotal = newCounterVec(
+		prometheus.CounterOpts{
+			Name: "voltha_onu_fec_corrected_bytes_total",
+			Help: "Number of total bytes corrected by the ONU FEC",
+		},
+		[]string{"cluster", "logical_device_id", "serial_number", "device_id", "entity_id"},
+	)
+	volthaOnuFecCorrectedCodeWordsTotal = newCounterVec(
+		prometheus.CounterOpts{
+			Name: "voltha_onu_fec_corrected_code_words_total",
+			Help: "Number of total code words corrected by the ONU FEC",
+		},
+		[]string{"cluster", "logical_device_id", "serial_number", "device_id", "entity_id"},
+	)
+	volthaOnuFecUncorrectableCodeWordsTotal = newCounterVec(
+		prometheus.CounterOpts{
+			Name: "voltha_onu_fec_uncorrectable_code_words_total",
+			Help: "Number of total code words the ONU FEC could not correct",
+		},
+		[]string{"cluster", "logical_device_id", "serial_number", "device_id", "entity_id"},
+	)
+	volthaOnuFecCodeWordsTotal = newCounterVec(
+		prometheus.CounterOpts{
+			Name: "voltha_onu_fec_code_words_total",
+			Help: "Number of total code words received by the ONU",
+		},
+		[]string{"cluster", "logical_device_id", "serial_number", "device_id", "entity_id"},
+	)
+	volthaOnuFecSecondsTotal = newCounterVec(
+		prometheus.CounterOpts{
+			Name: "voltha_onu_fec_seconds_total",
+			Help: "Number of total seconds with an uncorrectable code word",
+		},
+		[]string{"cluster", "logical_device_id", "serial_number", "device_id", "entity_id"},
+	)
+
+	volthaOnuFecHistory = newPMHistory("FEC_History",
+		func(cluster string, data *SliceData) []string {
+			return []string{
+				cluster,
+				data.Metadata.LogicalDeviceID,
+				data.Metadata.SerialNumber,
+				data.Metadata.DeviceID,
+				pmEntityID(data.Metrics),
+			}
+		},
+		map[string]pmCounter{
+			"corrected_bytes":          {volthaOnuFecCorrectedBytesTotal, func(m *Metrics) float64 { return m.CorrectedBytes }},
+			"corrected_code_words":     {volthaOnuFecCorrectedCodeWordsTotal, func(m *Metrics) float64 { return m.CorrectedCodeWords }},
+			"uncorrectable_code_words": {volthaOnuFecUncorrectableCodeWordsTotal, func(m *Metrics) float64 { return m.UncorrectableCodeWords }},
+			"total_code_words":         {volthaOnuFecCodeWordsTotal, func(m *Metrics) float64 { return m.TotalCodeWords }},
+			"fec_seconds":              {volthaOnuFecSecondsTotal, func(m *Metrics) float64 { return m.FecSeconds }},
+		},
+	)
+
+	volthaOnuUniFcsErrorsTotal = newCounterVec(
+		prometheus.CounterOpts{
+			Name: "voltha_onu_uni_fcs_errors_total",
+			Help: "Number of total frames received with an FCS error on the UNI",
+		},
+		[]string{"cluster", "logical_device_id", "serial_number", "device_id", "entity_id", "parent_class_id", "parent_entity_id"},
+	)
+	volthaOnuUniExcessiveCollisionsTotal = newCounterVec(
+		prometheus.CounterOpts{
+			Name: "voltha_onu_uni_excessive_collisions_total",
+			Help: "Number of total frames the UNI failed to transmit because of excessive collisions",
+		},
+		[]string{"cluster", "logical_device_id", "serial_number", "device_id", "entity_id", "parent_class_id", "parent_entity_id"},
+	)
+	volthaOnuUniLateCollisionsTotal = newCounterVec(
+		prometheus.CounterOpts{
+			Name: "voltha_onu_uni_late_collisions_total",
+			Help: "Number of total late collisions on the UNI",
+		},
+		[]string{"cluster", "logical_device_id", "serial_number", "device_id", "entity_id", "parent_class_id", "parent_entity_id"},
+	)
+	volthaOnuUniFramesTooLongTotal = newCounterVec(
+		prometheus.CounterOpts{
+			Name: "voltha_onu_uni_frames_too_long_total",
+			Help: "Number of total frames received exceeding the maximum size on the UNI",
+		},
+		[]string{"cluster", "logical_device_id", "serial_number", "device_id", "entity_id", "parent_class_id", "parent_entity_id"},
+	)
+	volthaOnuUniRxBufferOverflowsTotal = newCounterVec(
+		prometheus.CounterOpts{
+			Name: "voltha_onu_uni_rx_buffer_overflows_total",
+			Help: "Number of total frames received and lost because of buffer overflows on the UNI",
+		},
+		[]string{"cluster", "logical_device_id", "serial_number", "device_id", "entity_id", "parent_class_id", "parent_entity_id"},
+	)
+	volthaOnuUniTxBufferOverflowsTotal = newCounterVec(
+		prometheus.CounterOpts{
+			Name: "voltha_onu_uni_tx_buffer_overflows_total",
+			Help: "Number of total frames to transmit lost because of buffer overflows on the UNI",
+		},
+		[]string{"cluster", "logical_device_id", "serial_number", "device_id", "entity_id", "parent_class_id", "parent_entity_id"},
+	)
+	volthaOnuUniSingleCollisionFramesTotal = newCounterVec(
+		prometheus.CounterOpts{
+			Name: "voltha_onu_uni_single_collision_frames_total",
+			Help: "Number of total frames transmitted on the UNI after a single collision",
+		},
+		[]string{"cluster", "logical_device_id", "serial_number", "device_id", "entity_id", "parent_class_id", "parent_entity_id"},
+	)
+	volthaOnuUniMultipleCollisionFramesTotal = newCounterVec(
+		prometheus.CounterOpts{
+			Name: "voltha_onu_uni_multiple_collision_frames_total",
+			Help: "Number of total frames transmitted on the UNI after multiple collisions",
+		},
+		[]string{"cluster", "logical_device_id", "serial_number", "device_id", "entity_id", "parent_class_id", "parent_entity_id"},
+	)
+	volthaOnuUniSqeErrorsTotal = newCounterVec(
+		prometheus.CounterOpts{
+			Name: "voltha_onu_uni_sqe_errors_total",
+			Help: "Number of total SQE test errors on the UNI",
+		},
+		[]string{"cluster", "logical_device_id", "serial_number", "device_id", "entity_id", "parent_class_id", "parent_entity_id"},
+	)
+	volthaOnuUniDeferredTxTotal = newCounterVec(
+		prometheus.CounterOpts{
+			Name: "voltha_onu_uni_deferred_tx_total",
+			Help: "Number of total frames whose transmission was deferred on the UNI",
+		},
+		[]string{"cluster", "logical_device_id", "serial_number", "device_id", "entity_id", "parent_class_id", "parent_entity_id"},
+	)
+	volthaOnuUniInternalMacTxErrorsTotal = newCounterVec(
+		prometheus.CounterOpts{
+			Name: "voltha_onu_uni_internal_mac_tx_errors_total",
+			Help: "Number of total frames the UNI failed to transmit because of an internal MAC error",
+		},
+		[]string{"cluster", "logical_device_id", "serial_number", "device_id", "entity_id", "parent_class_id", "parent_entity_id"},
+	)
+	volthaOnuUniCarrierSenseErrorsTotal = newCounterVec(
+		prometheus.CounterOpts{
+			Name: "voltha_onu_uni_carrier_sense_errors_total",
+			Help: "Number of total carrier sense errors on the UNI",
+		},
+		[]string{"cluster", "logical_device_id", "serial_number", "device_id", "entity_id", "parent_class_id", "parent_entity_id"},
+	)
+	volthaOnuUniAlignmentErrorsTotal = newCounterVec(
+		prometheus.CounterOpts{
+			Name: "voltha_onu_uni_alignment_errors_total",
+			Help: "Number of total frames received with an alignment error on the UNI",
+		},
+		[]string{"cluster", "logical_device_id", "serial_number", "device_id", "entity_id", "parent_class_id", "parent_entity_id"},
+	)
+	volthaOnuUniInternalMacRxErrorsTotal = newCounterVec(
+		prometheus.CounterOpts{
+			Name: "voltha_onu_uni_internal_mac_rx_errors_total",
+			Help: "Number of total frames the UNI failed to receive because of an internal MAC error",
+		},
+		[]string{"cluster", "logical_device_id", "serial_number", "device_id", "entity_id", "parent_class_id", "parent_entity_id"},
+	)
+
+	volthaOnuUniHistory = newPMHistory("Ethernet_UNI_History",
+		func(cluster string, data *SliceData) []string {
+			return []string{
+				cluster,
+				data.Metadata.LogicalDeviceID,
+				data.Metadata.SerialNumber,
+				data.Metadata.DeviceID,
+				pmEntityID(data.Metrics),
+				data.Metadata.Context.ParentClassId,
+				data.Metadata.Context.ParentEntityId,
+			}
+		},
+		map[string]pmCounter{
+			"fcs_errors":                        {volthaOnuUniFcsErrorsTotal, func(m *Metrics) float64 { return m.FcsErrors }},
+			"excessive_collision_counter":       {volthaOnuUniExcessiveCollisionsTotal, func(m *Metrics) float64 { return m.ExcessiveCollisionCounter }},
+			"late_collision_counter":            {volthaOnuUniLateCollisionsTotal, func(m *Metrics) float64 { return m.LateCollisionCounter }},
+			"frames_too_long":                   {volthaOnuUniFramesTooLongTotal, func(m *Metrics) float64 { return m.FramesTooLong }},
+			"buffer_overflows_on_rx":            {volthaOnuUniRxBufferOverflowsTotal, func(m *Metrics) float64 { return m.BufferOverflowsOnRx }},
+			"buffer_overflows_on_tx":            {volthaOnuUniTxBufferOverflowsTotal, func(m *Metrics) float64 { return m.BufferOverflowsOnTx }},
+			"single_collision_frame_counter":    {volthaOnuUniSingleCollisionFramesTotal, func(m *Metrics) float64 { return m.SingleCollisionFrameCounter }},
+			"multiple_collisions_frame_counter": {volthaOnuUniMultipleCollisionFramesTotal, func(m *Metrics) float64 { return m.MultipleCollisionsFrameCounter }},
+			"sqe_counter":                       {volthaOnuUniSqeErrorsTotal, func(m *Metrics) float64 { return m.SqeCounter }},
+			"deferred_tx_counter":               {volthaOnuUniDeferredTxTotal, func(m *Metrics) float64 { return m.DeferredTxCounter }},
+			"internal_mac_tx_error_counter":     {volthaOnuUniInternalMacTxErrorsTotal, func(m *Metrics) float64 { return m.InternalMacTxErrorCounter }},
+			"carrier_sense_error_counter":       {volthaOnuUniCarrierSenseErrorsTotal, func(m *Metrics) float64 { return m.CarrierSenseErrorCounter }},
+			"alignment_error_counter":           {volthaOnuUniAlignmentErrorsTotal, func(m *Metrics) float64 { return m.AlignmentErrorCounter }},
+			"internal_mac_rx_error_counter":     {volthaOnuUniInternalMacRxErrorsTotal, func(m *Metrics) float64 { return m.InternalMacRxErrorCounter }},
+		},
+	)
+
+	// importer kpis
+	importerTemperatureCelsius = newGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "importer_temperature_celsius",
+			Help: "Temperature reported by the sensor",
+		},
+		[]string{"cluster", "device_id", "sensor_name", "sensor_type"},
+	)
+	importerFanSpeed = newGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "importer_fan_speed",
+			Help: "Fan speed, in the units of the sensor",
+		},
+		[]string{"cluster", "device_id", "sensor_name", "sensor_type", "units"},
+	)
+	importerPowerSupplyInputWatts = newGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "importer_power_supply_input_watts",
+			Help: "Input power of the power supply",
+		},
+		[]string{"cluster", "device_id", "sensor_name", "sensor_type"},
+	)
+	importerPowerSupplyOutputWatts = newGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "importer_power_supply_output_watts",
+			Help: "Output power of the power supply",
+		},
+		[]string{"cluster", "device_id", "sensor_name", "sensor_type"},
+	)
+	importerPowerSupplyInputVolts = newGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "importer_power_supply_input_volts",
+			Help: "Line input voltage of the power supply",
+		},
+		[]string{"cluster", "device_id", "sensor_name", "sensor_type"},
+	)
+	importerPowerSupplyHealthy = newGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "importer_power_supply_healthy",
+			Help: "Whether the power supply health is OK",
+		},
+		[]string{"cluster", "device_id", "sensor_name", "sensor_type"},
+	)
+	importerCPUUtilizationPercent = newGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "importer_cpu_utilization_percent",
+			Help: "Utilization of the processor",
+		},
+		[]string{"cluster", "device_id", "sensor_name", "sensor_type"},
+	)
+	importerMemoryUsedBytes = newGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "importer_memory_used_bytes",
+			Help: "Memory in use",
+		},
+		[]string{"cluster", "device_id", "sensor_name", "sensor_type"},
+	)
+	importerMemoryTotalBytes = newGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "importer_memory_total_bytes",
+			Help: "Total memory",
+		},
+		[]string{"cluster", "device_id", "sensor_name", "sensor_type"},
 	)
 
 	// onos.aaa kpis
-	onosaaaRxAcceptResponses = prometheus.NewGauge(
+	onosaaaRxAcceptResponses = newGaugeVec(
 		prometheus.GaugeOpts{
 			Name: "onosaaa_rx_accept_responses",
 			Help: "Number of access accept packets received from the server",
-		})
-	onosaaaRxRejectResponses = prometheus.NewGauge(
+		},
+		[]string{"cluster", "onos_instance"},
+	)
+	onosaaaRxRejectResponses = newGaugeVec(
 		prometheus.GaugeOpts{
 			Name: "onosaaa_rx_reject_responses",
 			Help: "Number of access reject packets received from the server",
-		})
-	onosaaaRxChallengeResponses = prometheus.NewGauge(
+		},
+		[]string{"cluster", "onos_instance"},
+	)
+	onosaaaRxChallengeResponses = newGaugeVec(
 		prometheus.GaugeOpts{
 			Name: "onosaaa_rx_challenge_response",
 			Help: "Number of access challenge packets received from the server",
-		})
-	onosaaaTxAccessRequests = prometheus.NewGauge(
+		},
+		[]string{"cluster", "onos_instance"},
+	)
+	onosaaaTxAccessRequests = newGaugeVec(
 		prometheus.GaugeOpts{
 			Name: "onosaaa_tx_access_requests",
 			Help: "Number of access request packets sent to the server",
-		})
-	onosaaaRxInvalidValidators = prometheus.NewGauge(
+		},
+		[]string{"cluster", "onos_instance"},
+	)
+	onosaaaRxInvalidValidators = newGaugeVec(
 		prometheus.GaugeOpts{
 			Name: "onosaaa_rx_invalid_validators",
 			Help: "Number of access response packets received from the server with an invalid validator",
-		})
-	onosaaaRxUnknownType = prometheus.NewGauge(
+		},
+		[]string{"cluster", "onos_instance"},
+	)
+	onosaaaRxUnknownType = newGaugeVec(
 		prometheus.GaugeOpts{
 			Name: "onosaaa_rx_unknown_type",
 			Help: "Number of packets of an unknown RADIUS type received from the accounting server",
-		})
-	onosaaaPendingRequests = prometheus.NewGauge(
+		},
+		[]string{"cluster", "onos_instance"},
+	)
+	onosaaaPendingRequests = newGaugeVec(
 		prometheus.GaugeOpts{
 			Name: "onosaaa_pending_responses",
 			Help: "Number of access request packets pending a response from the server",
-		})
-	onosaaaRxDroppedResponses = prometheus.NewGauge(
+		},
+		[]string{"cluster", "onos_instance"},
+	)
+	onosaaaRxDroppedResponses = newGaugeVec(
 		prometheus.GaugeOpts{
 			Name: "onosaaa_rx_dropped_responses",
 			Help: "Number of dropped packets received from the accounting server",
-		})
-	onosaaaRxMalformedResponses = prometheus.NewGauge(
+		},
+		[]string{"cluster", "onos_instance"},
+	)
+	onosaaaRxMalformedResponses = newGaugeVec(
 		prometheus.GaugeOpts{
 			Name: "onosaaa_rx_malformed_responses",
 			Help: "Number of malformed access response packets received from the server",
-		})
-	onosaaaRxUnknownserver = prometheus.NewGauge(
+		},
+		[]string{"cluster", "onos_instance"},
+	)
+	onosaaaRxUnknownserver = newGaugeVec(
 		prometheus.GaugeOpts{
 			Name: "onosaaa_rx_from_unknown_server",
 			Help: "Number of packets received from an unknown server",
-		})
-	onosaaaRequestRttMillis = prometheus.NewGauge(
+		},
+		[]string{"cluster", "onos_instance"},
+	)
+	onosaaaRequestRttMillis = newGaugeVec(
 		prometheus.GaugeOpts{
 			Name: "onosaaa_request_rttmillis",
 			Help: "Roundtrip packet time to the accounting server in Miliseconds",
-		})
-	onosaaaRequestReTx = prometheus.NewGauge(
+		},
+		[]string{"cluster", "onos_instance"},
+	)
+	onosaaaRequestReTx = newGaugeVec(
 		prometheus.GaugeOpts{
 			Name: "onosaaa_request_re_tx",
 			Help: "Number of access request packets retransmitted to the server",
-		})
+		},
+		[]string{"cluster", "onos_instance"},
+	)
+
+	// onos.aaa per port kpis
+	onosaaaPortEapolLogoffRx = newGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "onosaaa_port_eapol_logoff_rx",
+			Help: "Number of EAPOL logoff packets received on the port",
+		},
+		[]string{"cluster", "onos_instance", "device_id", "port_number"},
+	)
+	onosaaaPortEapolAuthSuccessTrans = newGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "onosaaa_port_eapol_auth_success_trans",
+			Help: "Number of authentications which succeeded on the port",
+		},
+		[]string{"cluster", "onos_instance", "device_id", "port_number"},
+	)
+	onosaaaPortEapolAuthFailureTrans = newGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "onosaaa_port_eapol_auth_failure_trans",
+			Help: "Number of authentications which failed on the port",
+		},
+		[]string{"cluster", "onos_instance", "device_id", "port_number"},
+	)
+	onosaaaPortEapolStartReqTrans = newGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "onosaaa_port_eapol_start_req_trans",
+			Help: "Number of EAPOL start requests on the port",
+		},
+		[]string{"cluster", "onos_instance", "device_id", "port_number"},
+	)
+	onosaaaPortEapolFramesTx = newGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "onosaaa_port_eapol_frames_tx",
+			Help: "Number of EAPOL frames sent on the port",
+		},
+		[]string{"cluster", "onos_instance", "device_id", "port_number"},
+	)
+	onosaaaPortValidEapolFramesRx = newGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "onosaaa_port_valid_eapol_frames_rx",
+			Help: "Number of valid EAPOL frames received on the port",
+		},
+		[]string{"cluster", "onos_instance", "device_id", "port_number"},
+	)
+	onosaaaPortPendingSupplicantResponses = newGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "onosaaa_port_pending_supplicant_responses",
+			Help: "Number of supplicants of the port pending a response",
+		},
+		[]string{"cluster", "onos_instance", "device_id", "port_number"},
+	)
+	onosaaaPortAuthStateIdle = newGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "onosaaa_port_auth_state_idle",
+			Help: "Number of supplicants of the port in the idle state",
+		},
+		[]string{"cluster", "onos_instance", "device_id", "port_number"},
+	)
 )
 
-func exportVolthaKPI(kpi VolthaKPI) {
+// validateSliceData returns an error if a slice misses a part its metrics
+// are exported from
+func validateSliceData(data *SliceData) error {
+	switch {
+	case data == nil:
+		return errors.New("null slice_data")
+	case data.Metadata == nil:
+		return errors.New("slice_data without metadata")
+	case data.Metadata.Context == nil:
+		return fmt.Errorf("%s slice_data without context", data.Metadata.Title)
+	case data.Metrics == nil:
+		return fmt.Errorf("%s slice_data without metrics", data.Metadata.Title)
+	}
+	return nil
+}
+
+func exportVolthaKPI(cluster string, published time.Time, kpi VolthaKPI) error {
+	// the whole KPI is rejected, rather than exported in part
+	for _, data := range kpi.SliceDatas {
+		if err := validateSliceData(data); err != nil {
+			return &exportError{reason: reasonInvalidKPI, err: err}
+		}
+	}
 
 	for _, data := range kpi.SliceDatas {
+		ts := data.Metadata.Timestamp
+		if ts == 0 {
+			ts = kpi.Timestamp
+		}
+		at := kpiTime(ts)
+		if data.Metadata.DeviceID != "" && !at.IsZero() {
+			volthaLastKpiTimestamp.WithLabelValues(
+				cluster,
+				data.Metadata.LogicalDeviceID,
+				data.Metadata.SerialNumber,
+				data.Metadata.DeviceID,
+			).Set(ts)
+			if !published.IsZero() {
+				volthaKpiTimestampSkew.WithLabelValues(
+					cluster,
+					data.Metadata.LogicalDeviceID,
+					data.Metadata.SerialNumber,
+					data.Metadata.DeviceID,
+				).Set(published.Sub(at).Seconds())
+			}
+		}
+
 		switch title := data.Metadata.Title; title {
 		case "Ethernet", "PON":
-			volthaTxBytesTotal.WithLabelValues(
+			volthaTxBytesTotal.at(at).WithLabelValues(
+				cluster,
 				data.Metadata.LogicalDeviceID,
 				data.Metadata.SerialNumber,
 				data.Metadata.DeviceID,
@@ -192,7 +745,8 @@ func exportVolthaKPI(kpi VolthaKPI) {
 				data.Metadata.Title,
 			).Set(data.Metrics.TxBytes)
 
-			volthaRxBytesTotal.WithLabelValues(
+			volthaRxBytesTotal.at(at).WithLabelValues(
+				cluster,
 				data.Metadata.LogicalDeviceID,
 				data.Metadata.SerialNumber,
 				data.Metadata.DeviceID,
@@ -202,7 +756,8 @@ func exportVolthaKPI(kpi VolthaKPI) {
 				data.Metadata.Title,
 			).Set(data.Metrics.RxBytes)
 
-			volthaTxPacketsTotal.WithLabelValues(
+			volthaTxPacketsTotal.at(at).WithLabelValues(
+				cluster,
 				data.Metadata.LogicalDeviceID,
 				data.Metadata.SerialNumber,
 				data.Metadata.DeviceID,
@@ -212,7 +767,8 @@ func exportVolthaKPI(kpi VolthaKPI) {
 				data.Metadata.Title,
 			).Set(data.Metrics.TxPackets)
 
-			volthaRxPacketsTotal.WithLabelValues(
+			volthaRxPacketsTotal.at(at).WithLabelValues(
+				cluster,
 				data.Metadata.LogicalDeviceID,
 				data.Metadata.SerialNumber,
 				data.Metadata.DeviceID,
@@ -222,7 +778,8 @@ func exportVolthaKPI(kpi VolthaKPI) {
 				data.Metadata.Title,
 			).Set(data.Metrics.RxPackets)
 
-			volthaTxErrorPacketsTotal.WithLabelValues(
+			volthaTxErrorPacketsTotal.at(at).WithLabelValues(
+				cluster,
 				data.Metadata.LogicalDeviceID,
 				data.Metadata.SerialNumber,
 				data.Metadata.DeviceID,
@@ -232,7 +789,8 @@ func exportVolthaKPI(kpi VolthaKPI) {
 				data.Metadata.Title,
 			).Set(data.Metrics.TxErrorPackets)
 
-			volthaRxErrorPacketsTotal.WithLabelValues(
+			volthaRxErrorPacketsTotal.at(at).WithLabelValues(
+				cluster,
 				data.Metadata.LogicalDeviceID,
 				data.Metadata.SerialNumber,
 				data.Metadata.DeviceID,
@@ -242,65 +800,8 @@ func exportVolthaKPI(kpi VolthaKPI) {
 				data.Metadata.Title,
 			).Set(data.Metrics.RxErrorPackets)
 
-			// TODO add metrics for:
-			// TxBcastPackets
-			// TxUnicastPackets
-			// TxMulticastPackets
-			// RxBcastPackets
-			// RxMulticastPackets
-
-		case "Ethernet_Bridge_Port_History":
-			if data.Metadata.Context.Upstream == "True" {
-				// ONU. Extended Ethernet statistics.
-				volthaTxPacketsTotal.WithLabelValues(
-					data.Metadata.LogicalDeviceID,
-					data.Metadata.SerialNumber,
-					data.Metadata.DeviceID,
-					"NA", // InterfaceID
-					"NA", // PonID
-					"NA", // PortNumber
-					data.Metadata.Title,
-				).Add(data.Metrics.Packets)
-
-				volthaTxBytesTotal.WithLabelValues(
-					data.Metadata.LogicalDeviceID,
-					data.Metadata.SerialNumber,
-					data.Metadata.DeviceID,
-					"NA", // InterfaceID
-					"NA", // PonID
-					"NA", // PortNumber
-					data.Metadata.Title,
-				).Add(data.Metrics.Octets)
-			} else {
-				// ONU. Extended Ethernet statistics.
-				volthaRxPacketsTotal.WithLabelValues(
-					data.Metadata.LogicalDeviceID,
-					data.Metadata.SerialNumber,
-					data.Metadata.DeviceID,
-					"NA", // InterfaceID
-					"NA", // PonID
-					"NA", // PortNumber
-					data.Metadata.Title,
-				).Add(data.Metrics.Packets)
-
-				volthaRxBytesTotal.WithLabelValues(
-					data.Metadata.LogicalDeviceID,
-					data.Metadata.SerialNumber,
-					data.Metadata.DeviceID,
-					"NA", // InterfaceID
-					"NA", // PonID
-					"NA", // PortNumber
-					data.Metadata.Title,
-				).Add(data.Metrics.Octets)
-			}
-
-		case "Ethernet_UNI_History":
-			// ONU. Do nothing.
-
-		case "FEC_History":
-			// ONU. Do Nothing.
-
-			volthaTxBytesTotal.WithLabelValues(
+			volthaTxBcastPacketsTotal.at(at).WithLabelValues(
+				cluster,
 				data.Metadata.LogicalDeviceID,
 				data.Metadata.SerialNumber,
 				data.Metadata.DeviceID,
@@ -308,9 +809,10 @@ func exportVolthaKPI(kpi VolthaKPI) {
 				data.Metadata.Context.PonID,
 				data.Metadata.Context.PortNumber,
 				data.Metadata.Title,
-			).Set(data.Metrics.TxBytes)
+			).Set(data.Metrics.TxBcastPackets)
 
-			volthaRxBytesTotal.WithLabelValues(
+			volthaTxUcastPacketsTotal.at(at).WithLabelValues(
+				cluster,
 				data.Metadata.LogicalDeviceID,
 				data.Metadata.SerialNumber,
 				data.Metadata.DeviceID,
@@ -318,9 +820,10 @@ func exportVolthaKPI(kpi VolthaKPI) {
 				data.Metadata.Context.PonID,
 				data.Metadata.Context.PortNumber,
 				data.Metadata.Title,
-			).Set(data.Metrics.RxBytes)
+			).Set(data.Metrics.TxUnicastPackets)
 
-			volthaTxPacketsTotal.WithLabelValues(
+			volthaTxMcastPacketsTotal.at(at).WithLabelValues(
+				cluster,
 				data.Metadata.LogicalDeviceID,
 				data.Metadata.SerialNumber,
 				data.Metadata.DeviceID,
@@ -328,9 +831,10 @@ func exportVolthaKPI(kpi VolthaKPI) {
 				data.Metadata.Context.PonID,
 				data.Metadata.Context.PortNumber,
 				data.Metadata.Title,
-			).Set(data.Metrics.TxPackets)
+			).Set(data.Metrics.TxMulticastPackets)
 
-			volthaRxPacketsTotal.WithLabelValues(
+			volthaRxBcastPacketsTotal.at(at).WithLabelValues(
+				cluster,
 				data.Metadata.LogicalDeviceID,
 				data.Metadata.SerialNumber,
 				data.Metadata.DeviceID,
@@ -338,9 +842,10 @@ func exportVolthaKPI(kpi VolthaKPI) {
 				data.Metadata.Context.PonID,
 				data.Metadata.Context.PortNumber,
 				data.Metadata.Title,
-			).Set(data.Metrics.RxPackets)
+			).Set(data.Metrics.RxBcastPackets)
 
-			volthaTxErrorPacketsTotal.WithLabelValues(
+			volthaRxUcastPacketsTotal.at(at).WithLabelValues(
+				cluster,
 				data.Metadata.LogicalDeviceID,
 				data.Metadata.SerialNumber,
 				data.Metadata.DeviceID,
@@ -348,9 +853,10 @@ func exportVolthaKPI(kpi VolthaKPI) {
 				data.Metadata.Context.PonID,
 				data.Metadata.Context.PortNumber,
 				data.Metadata.Title,
-			).Set(data.Metrics.TxErrorPackets)
+			).Set(data.Metrics.RxUnicastPackets)
 
-			volthaRxErrorPacketsTotal.WithLabelValues(
+			volthaRxMcastPacketsTotal.at(at).WithLabelValues(
+				cluster,
 				data.Metadata.LogicalDeviceID,
 				data.Metadata.SerialNumber,
 				data.Metadata.DeviceID,
@@ -358,120 +864,366 @@ func exportVolthaKPI(kpi VolthaKPI) {
 				data.Metadata.Context.PonID,
 				data.Metadata.Context.PortNumber,
 				data.Metadata.Title,
-			).Set(data.Metrics.RxErrorPackets)
+			).Set(data.Metrics.RxMulticastPackets)
+
+		case "Ethernet_Bridge_Port_History":
+			// ONU. Extended Ethernet statistics, per 15 minutes interval.
+			if err := volthaOnuBridgePortHistory.observe(cluster, data); err != nil {
+				return err
+			}
 
-			// TODO add metrics for:
-			// TxBcastPackets
-			// TxUnicastPackets
-			// TxMulticastPackets
-			// RxBcastPackets
-			// RxMulticastPackets
+		case "Ethernet_UNI_History":
+			// ONU. Ethernet statistics of the UNI, per 15 minutes interval.
+			if err := volthaOnuUniHistory.observe(cluster, data); err != nil {
+				return err
+			}
+
+		case "FEC_History":
+			// ONU. Forward error correction of the ANI, per 15 minutes interval.
+			if err := volthaOnuFecHistory.observe(cluster, data); err != nil {
+				return err
+			}
+
+		case "PON_Optical":
+			// OLT or ONU. Optical levels of the PON transceivers.
+			exportPonOptical(cluster, at, data)
 
 		case "voltha.internal":
-			// Voltha Internal. Do nothing.
+			// Voltha Internal. The metrics vary between releases.
+			exportVolthaInternal(cluster, at, data)
+		}
+	}
+	return nil
+}
+
+// exportPonOptical exports the optical levels of a PON_Optical slice. The
+// slices with a port number in their context are those of an OLT PON port,
+// the others those of an ONU ANI. Levels missing from the slice are left
+// unchanged.
+func exportPonOptical(cluster string, at time.Time, data *SliceData) {
+	var gauges []*gaugeVec
+	var labels []string
+	if data.Metadata.Context.PortNumber != "" {
+		gauges = []*gaugeVec{
+			volthaOltPonOpticalTxPowerDbm,
+			volthaOltPonOpticalRxPowerDbm,
+			volthaOltPonLaserBiasCurrentMilliamperes,
+			volthaOltPonTransceiverVoltageVolts,
+			volthaOltPonTransceiverTemperatureCelsius,
+		}
+		labels = []string{
+			cluster,
+			data.Metadata.LogicalDeviceID,
+			data.Metadata.SerialNumber,
+			data.Metadata.DeviceID,
+			data.Metadata.Context.InterfaceID,
+			data.Metadata.Context.PonID,
+			data.Metadata.Context.PortNumber,
+		}
+	} else {
+		ponID := data.Metadata.Context.PonID
+		if ponID == "" {
+			ponID = data.Metadata.Context.InterfaceID
+		}
+		gauges = []*gaugeVec{
+			volthaOnuOpticalTxPowerDbm,
+			volthaOnuOpticalRxPowerDbm,
+			volthaOnuLaserBiasCurrentMilliamperes,
+			volthaOnuTransceiverVoltageVolts,
+			volthaOnuTransceiverTemperatureCelsius,
+		}
+		labels = []string{
+			cluster,
+			data.Metadata.LogicalDeviceID,
+			data.Metadata.SerialNumber,
+			data.Metadata.DeviceID,
+			ponID,
+		}
+	}
+
+	for i, value := range []*float64{
+		data.Metrics.TxPowerDbm,
+		data.Metrics.RxPowerDbm,
+		data.Metrics.LaserBiasCurrent,
+		data.Metrics.SupplyVoltage,
+		data.Metrics.Temperature,
+	} {
+		if value != nil {
+			gauges[i].at(at).WithLabelValues(labels...).Set(*value)
 		}
 	}
 }
 
-func exportOnosKPI(kpi OnosKPI) {
+func exportOnosKPI(cluster string, kpi OnosKPI) {
 
 	for _, data := range kpi.Ports {
+		if data == nil {
+			continue
+		}
 
 		onosTxBytesTotal.WithLabelValues(
+			cluster,
 			kpi.DeviceID,
 			data.PortID,
 		).Set(data.TxBytes)
 
 		onosRxBytesTotal.WithLabelValues(
+			cluster,
 			kpi.DeviceID,
 			data.PortID,
 		).Set(data.RxBytes)
 
 		onosTxPacketsTotal.WithLabelValues(
+			cluster,
 			kpi.DeviceID,
 			data.PortID,
 		).Set(data.TxPackets)
 
 		onosRxPacketsTotal.WithLabelValues(
+			cluster,
 			kpi.DeviceID,
 			data.PortID,
 		).Set(data.RxPackets)
 
 		onosTxDropPacketsTotal.WithLabelValues(
+			cluster,
 			kpi.DeviceID,
 			data.PortID,
 		).Set(data.TxPacketsDrop)
 
 		onosRxDropPacketsTotal.WithLabelValues(
+			cluster,
 			kpi.DeviceID,
 			data.PortID,
 		).Set(data.RxPacketsDrop)
 	}
 }
 
-func exportImporterKPI(kpi ImporterKPI) {
-	// TODO: add metrics for importer data
-	logger.Info("To be implemented")
+func exportImporterKPI(cluster string, kpi ImporterKPI) {
+
+	for _, sensor := range kpi.Temperatures {
+		if sensor == nil {
+			continue
+		}
+		importerTemperatureCelsius.WithLabelValues(
+			cluster,
+			kpi.DeviceID,
+			sensor.Name,
+			sensor.Type,
+		).Set(sensor.Reading)
+	}
+
+	for _, sensor := range kpi.Fans {
+		if sensor == nil {
+			continue
+		}
+		importerFanSpeed.WithLabelValues(
+			cluster,
+			kpi.DeviceID,
+			sensor.Name,
+			sensor.Type,
+			sensor.Units,
+		).Set(sensor.Reading)
+	}
+
+	for _, psu := range kpi.PowerSupplies {
+		if psu == nil {
+			continue
+		}
+		importerPowerSupplyInputWatts.WithLabelValues(
+			cluster,
+			kpi.DeviceID,
+			psu.Name,
+			psu.Type,
+		).Set(psu.InputWatts)
+
+		importerPowerSupplyOutputWatts.WithLabelValues(
+			cluster,
+			kpi.DeviceID,
+			psu.Name,
+			psu.Type,
+		).Set(psu.OutputWatts)
+
+		importerPowerSupplyInputVolts.WithLabelValues(
+			cluster,
+			kpi.DeviceID,
+			psu.Name,
+			psu.Type,
+		).Set(psu.InputVoltage)
+
+		if psu.Health != "" {
+			healthy := 0.0
+			if psu.Health == "OK" {
+				healthy = 1
+			}
+			importerPowerSupplyHealthy.WithLabelValues(
+				cluster,
+				kpi.DeviceID,
+				psu.Name,
+				psu.Type,
+			).Set(healthy)
+		}
+	}
+
+	for _, cpu := range kpi.Processors {
+		if cpu == nil {
+			continue
+		}
+		importerCPUUtilizationPercent.WithLabelValues(
+			cluster,
+			kpi.DeviceID,
+			cpu.Name,
+			cpu.Type,
+		).Set(cpu.UtilizationPercent)
+	}
+
+	for _, memory := range kpi.Memory {
+		if memory == nil {
+			continue
+		}
+		importerMemoryUsedBytes.WithLabelValues(
+			cluster,
+			kpi.DeviceID,
+			memory.Name,
+			memory.Type,
+		).Set(memory.UsedBytes)
+
+		importerMemoryTotalBytes.WithLabelValues(
+			cluster,
+			kpi.DeviceID,
+			memory.Name,
+			memory.Type,
+		).Set(memory.TotalBytes)
+	}
 }
 
-func exportOnosAaaKPI(kpi OnosAaaKPI) {
+func exportOnosAaaKPI(cluster string, kpi OnosAaaKPI) {
+	instance := kpi.InstanceID
+	if instance == "" {
+		instance = kpi.ClusterNode
+	}
+
+	onosaaaRxAcceptResponses.WithLabelValues(cluster, instance).Set(kpi.RxAcceptResponses)
+
+	onosaaaRxRejectResponses.WithLabelValues(cluster, instance).Set(kpi.RxRejectResponses)
+
+	onosaaaRxChallengeResponses.WithLabelValues(cluster, instance).Set(kpi.RxChallengeResponses)
+
+	onosaaaTxAccessRequests.WithLabelValues(cluster, instance).Set(kpi.TxAccessRequests)
+
+	onosaaaRxInvalidValidators.WithLabelValues(cluster, instance).Set(kpi.RxInvalidValidators)
+
+	onosaaaRxUnknownType.WithLabelValues(cluster, instance).Set(kpi.RxUnknownType)
 
-	onosaaaRxAcceptResponses.Set(kpi.RxAcceptResponses)
+	onosaaaPendingRequests.WithLabelValues(cluster, instance).Set(kpi.PendingRequests)
 
-	onosaaaRxRejectResponses.Set(kpi.RxRejectResponses)
+	onosaaaRxDroppedResponses.WithLabelValues(cluster, instance).Set(kpi.RxDroppedResponses)
 
-	onosaaaRxChallengeResponses.Set(kpi.RxChallengeResponses)
+	onosaaaRxMalformedResponses.WithLabelValues(cluster, instance).Set(kpi.RxMalformedResponses)
 
-	onosaaaTxAccessRequests.Set(kpi.TxAccessRequests)
+	onosaaaRxUnknownserver.WithLabelValues(cluster, instance).Set(kpi.RxUnknownserver)
 
-	onosaaaRxInvalidValidators.Set(kpi.RxInvalidValidators)
+	onosaaaRequestRttMillis.WithLabelValues(cluster, instance).Set(kpi.RequestRttMillis)
 
-	onosaaaRxUnknownType.Set(kpi.RxUnknownType)
+	// every statistics reporting a round trip time is an observation
+	if onosaaaRequestRtt != nil && kpi.RequestRttMillis > 0 {
+		onosaaaRequestRtt.WithLabelValues(cluster, instance).Observe(kpi.RequestRttMillis)
+	}
+
+	onosaaaRequestReTx.WithLabelValues(cluster, instance).Set(kpi.RequestReTx)
+
+	for _, port := range kpi.Ports {
+		if port == nil {
+			continue
+		}
+		onosaaaPortEapolLogoffRx.WithLabelValues(
+			cluster,
+			instance,
+			port.DeviceID,
+			port.PortNumber,
+		).Set(port.EapolLogoffRx)
+
+		onosaaaPortEapolAuthSuccessTrans.WithLabelValues(
+			cluster,
+			instance,
+			port.DeviceID,
+			port.PortNumber,
+		).Set(port.EapolAuthSuccessTrans)
 
-	onosaaaPendingRequests.Set(kpi.PendingRequests)
+		onosaaaPortEapolAuthFailureTrans.WithLabelValues(
+			cluster,
+			instance,
+			port.DeviceID,
+			port.PortNumber,
+		).Set(port.EapolAuthFailureTrans)
 
-	onosaaaRxDroppedResponses.Set(kpi.RxDroppedResponses)
+		onosaaaPortEapolStartReqTrans.WithLabelValues(
+			cluster,
+			instance,
+			port.DeviceID,
+			port.PortNumber,
+		).Set(port.EapolStartReqTrans)
 
-	onosaaaRxMalformedResponses.Set(kpi.RxMalformedResponses)
+		onosaaaPortEapolFramesTx.WithLabelValues(
+			cluster,
+			instance,
+			port.DeviceID,
+			port.PortNumber,
+		).Set(port.EapolFramesTx)
 
-	onosaaaRxUnknownserver.Set(kpi.RxUnknownserver)
+		onosaaaPortValidEapolFramesRx.WithLabelValues(
+			cluster,
+			instance,
+			port.DeviceID,
+			port.PortNumber,
+		).Set(port.ValidEapolFramesRx)
 
-	onosaaaRequestRttMillis.Set(kpi.RequestRttMillis)
+		onosaaaPortPendingSupplicantResponses.WithLabelValues(
+			cluster,
+			instance,
+			port.DeviceID,
+			port.PortNumber,
+		).Set(port.PendingResSupp)
 
-	onosaaaRequestReTx.Set(kpi.RequestReTx)
+		onosaaaPortAuthStateIdle.WithLabelValues(
+			cluster,
+			instance,
+			port.DeviceID,
+			port.PortNumber,
+		).Set(port.AuthStateIdle)
+	}
 }
 
-func export(topic *string, data []byte) {
-	switch *topic {
-	case "voltha.kpis":
-		kpi := VolthaKPI{}
-		err := json.Unmarshal(data, &kpi)
+func init() {
+	registerDecoder("voltha", DecoderFunc(func(msg *Message) error {
+		kpi, err := decodeVolthaKPI(msg.Value)
 		if err != nil {
-			log.Fatal(err)
+			return &exportError{reason: reasonUnmarshal, err: err}
 		}
-		exportVolthaKPI(kpi)
-	case "onos.kpis":
+		return exportVolthaKPI(msg.Cluster, msg.Timestamp, kpi)
+	}))
+	registerDecoder("onos", DecoderFunc(func(msg *Message) error {
 		kpi := OnosKPI{}
-		err := json.Unmarshal(data, &kpi)
-		if err != nil {
-			log.Fatal(err)
+		if err := json.Unmarshal(msg.Value, &kpi); err != nil {
+			return &exportError{reason: reasonUnmarshal, err: err}
 		}
-		exportOnosKPI(kpi)
-	case "importer.kpis":
+		exportOnosKPI(msg.Cluster, kpi)
+		return nil
+	}))
+	registerDecoder("importer", DecoderFunc(func(msg *Message) error {
 		kpi := ImporterKPI{}
-		err := json.Unmarshal(data, &kpi)
-		if err != nil {
-			log.Fatal(err)
+		if err := json.Unmarshal(msg.Value, &kpi); err != nil {
+			return &exportError{reason: reasonUnmarshal, err: err}
 		}
-		exportImporterKPI(kpi)
-	case "onos.aaa.stats.kpis":
+		exportImporterKPI(msg.Cluster, kpi)
+		return nil
+	}))
+	registerDecoder("onosaaa", DecoderFunc(func(msg *Message) error {
 		kpi := OnosAaaKPI{}
-		err := json.Unmarshal(data, &kpi)
-		if err != nil {
-			log.Fatal(err)
+		if err := json.Unmarshal(msg.Value, &kpi); err != nil {
+			return &exportError{reason: reasonUnmarshal, err: err}
 		}
-		exportOnosAaaKPI(kpi)
-	default:
-		logger.Warn("Unexpected export. Should not come here")
-	}
+		exportOnosAaaKPI(msg.Cluster, kpi)
+		return nil
+	}))
 }
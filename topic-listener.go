@@ -15,38 +15,422 @@
 package main
 
 import (
-	"os"
-	"os/signal"
+	"context"
+	"sort"
 	"sync"
+	"sync/atomic"
+	"time"
 
-	"github.com/Shopify/sarama"
 	"gerrit.opencord.org/kafka-topic-exporter/common/logger"
+	"github.com/Shopify/sarama"
+)
+
+const (
+	// resume from the offset committed by the consumer group, falling
+	// back to the oldest available offset for a new group
+	startOffsetCommitted = "committed"
+	// start from the oldest available offset
+	startOffsetOldest = "oldest"
+	// skip everything published before the exporter started
+	startOffsetNewest = "newest"
 )
 
-func topicListener(topic *string, master sarama.Consumer, wg sync.WaitGroup) {
-	logger.Info("Starting topicListener for [%s]", *topic)
-	defer wg.Done()
-	consumer, err := master.ConsumePartition(*topic, 0, sarama.OffsetOldest)
+// topicHandler holds the settings and the start positions of a topic
+// consumed by a groupConsumer
+type topicHandler struct {
+	topic   TopicInfo
+	decoder string
+
+	// start_offset, when it is a duration, e.g. 15m
+	since time.Duration
+}
+
+func newTopicHandler(topic TopicInfo) *topicHandler {
+	h := &topicHandler{
+		topic:   topic,
+		decoder: decoderFor(topic),
+	}
+	if _, ok := decoders[h.decoder]; !ok {
+		logger.Error("Unknown decoder [%s] for [%s], its messages are skipped, available decoders: %v",
+			h.decoder, topic.Name, decoderNames())
+	}
+	switch topic.StartOffset {
+	case "":
+		h.topic.StartOffset = startOffsetCommitted
+	case startOffsetCommitted, startOffsetOldest, startOffsetNewest:
+	default:
+		since, err := time.ParseDuration(topic.StartOffset)
+		if err != nil || since <= 0 {
+			logger.Error("Invalid start_offset [%s] for [%s], resuming from committed offset",
+				topic.StartOffset, topic.Name)
+			h.topic.StartOffset = startOffsetCommitted
+		}
+		h.since = since
+	}
+	return h
+}
+
+// startOffset returns the offset a partition without committed offset is
+// consumed from, or false to start from the initial offset of the group
+func (h *topicHandler) startOffset(client sarama.Client, partition int32) (int64, bool, error) {
+	var offset int64
+	var err error
+	switch h.topic.StartOffset {
+	case startOffsetCommitted:
+		return 0, false, nil
+	case startOffsetOldest:
+		offset, err = client.GetOffset(h.topic.Name, partition, sarama.OffsetOldest)
+	case startOffsetNewest:
+		offset, err = client.GetOffset(h.topic.Name, partition, sarama.OffsetNewest)
+	default:
+		// kafka wants the timestamp in milliseconds, and answers with -1
+		// when no message was published since then
+		ts := time.Now().Add(-h.since).UnixNano() / int64(time.Millisecond)
+		offset, err = client.GetOffset(h.topic.Name, partition, ts)
+		if err == nil && offset < 0 {
+			offset, err = client.GetOffset(h.topic.Name, partition, sarama.OffsetNewest)
+		}
+	}
+	return offset, err == nil, err
+}
+
+// groupConsumer is the member of the consumer group of a cluster, it
+// consumes all the topics of the cluster, so that a reconnection or a new
+// topic rebalances the group once instead of once per topic
+type groupConsumer struct {
+	broker BrokerInfo
+	config *sarama.Config
+	// where messages which cannot be exported are forwarded, may be nil
+	deadLetters *deadLetterQueue
+	// delay between reconnections, reset once the group is joined
+	retry backoff
+
+	mu       sync.Mutex
+	handlers map[string]*topicHandler
+	client   sarama.Client
+	// signals that the topics changed, so that the group is joined again
+	// with the new subscription
+	changed chan struct{}
+}
+
+func newGroupConsumer(broker BrokerInfo, config *sarama.Config, deadLetters *deadLetterQueue) *groupConsumer {
+	brokerUp.WithLabelValues(broker.Name).Set(0)
+	return &groupConsumer{
+		broker:      broker,
+		config:      config,
+		deadLetters: deadLetters,
+		handlers:    make(map[string]*topicHandler),
+		changed:     make(chan struct{}, 1),
+	}
+}
+
+// addTopic subscribes the group member to a topic
+func (c *groupConsumer) addTopic(topic TopicInfo) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, exists := c.handlers[topic.Name]; exists {
+		return
+	}
+	logger.Info("Consuming [%s] in group [%s] of [%s]", topic.Name, c.broker.Group, c.broker.Name)
+	c.handlers[topic.Name] = newTopicHandler(topic)
+	connections.set(c.broker.Name, topic.Name, false)
+	c.notify()
+}
+
+// removeTopic unsubscribes the group member from a topic
+func (c *groupConsumer) removeTopic(name string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, exists := c.handlers[name]; !exists {
+		return
+	}
+	delete(c.handlers, name)
+	connections.remove(c.broker.Name, name)
+	c.notify()
+}
+
+func (c *groupConsumer) notify() {
+	select {
+	case c.changed <- struct{}{}:
+	default:
+	}
+}
+
+// topics returns the subscribed topics
+func (c *groupConsumer) topics() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	topics := make([]string, 0, len(c.handlers))
+	for name := range c.handlers {
+		topics = append(topics, name)
+	}
+	sort.Strings(topics)
+	return topics
+}
+
+func (c *groupConsumer) handler(topic string) *topicHandler {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.handlers[topic]
+}
+
+// setConnected sets the connection state of the cluster and of all the
+// subscribed topics, they share the connection of the group member
+func (c *groupConsumer) setConnected(connected bool) {
+	up := 0.0
+	if connected {
+		up = 1
+	}
+	brokerUp.WithLabelValues(c.broker.Name).Set(up)
+	for _, topic := range c.topics() {
+		connections.set(c.broker.Name, topic, connected)
+	}
+}
+
+// run consumes the topics for as long as the context is not cancelled,
+// reconnecting to the broker whenever the consumer fails
+func (c *groupConsumer) run(ctx context.Context) {
+	logger.Info("Starting consumer of [%s] in group [%s]", c.broker.Name, c.broker.Group)
+	for {
+		err := c.consume(ctx)
+		c.setConnected(false)
+		if ctx.Err() != nil {
+			return
+		}
+		delay := c.retry.next()
+		logger.Error("Consumer of [%s] failed: %s, reconnecting in %s", c.broker.Name, err, delay)
+		if !wait(ctx, delay) {
+			return
+		}
+		consumerReconnects.WithLabelValues(c.broker.Name).Inc()
+	}
+}
+
+// consume connects to the broker and consumes the topics until the context
+// is cancelled or the consumer fails
+func (c *groupConsumer) consume(ctx context.Context) error {
+	client, err := sarama.NewClient(c.broker.bootstrapHosts(), c.config)
 	if err != nil {
-		logger.Error("topicListener panic")
-		panic(err)
+		return err
 	}
-	signals := make(chan os.Signal, 1)
-	signal.Notify(signals, os.Interrupt)
-	doneCh := make(chan struct{})
+	defer func() {
+		if err := client.Close(); err != nil {
+			logger.Error("%s", err)
+		}
+	}()
+	// consumer groups can re-use but not share a client
+	group, err := sarama.NewConsumerGroupFromClient(c.broker.Group, client)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		logger.Debug("Closing consumer group of [%s]", c.broker.Name)
+		if err := group.Close(); err != nil {
+			logger.Error("%s", err)
+		}
+	}()
+
 	go func() {
-		for {
+		for err := range group.Errors() {
+			logger.Error("%s", err)
+		}
+	}()
+
+	c.mu.Lock()
+	c.client = client
+	c.mu.Unlock()
+	for {
+		// a change signalled until now is part of the topics
+		select {
+		case <-c.changed:
+		default:
+		}
+		topics := c.topics()
+		if len(topics) == 0 {
+			// nothing to consume until a topic is discovered
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-c.changed:
+				continue
+			}
+		}
+
+		// the session is ended when the topics change, so that the group
+		// is joined again with the new subscription
+		session, cancel := context.WithCancel(ctx)
+		go func() {
 			select {
-			case err := <-consumer.Errors():
-				logger.Error("%s", err)
-			case msg := <-consumer.Messages():
-				logger.Debug("Message on %s: %s", *topic, string(msg.Value))
-				export(topic, msg.Value)
-			case <-signals:
-				logger.Warn("Interrupt is detected")
-				doneCh <- struct{}{}
+			case <-c.changed:
+				cancel()
+			case <-session.Done():
 			}
+		}()
+		// Consume returns at the end of every group session, i.e. on
+		// rebalance, so we need to call it again to rejoin the group
+		err := group.Consume(session, topics, c)
+		cancel()
+		if err != nil {
+			return err
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+	}
+}
+
+// offsetMover is the part of a group session moving the offsets of its
+// partitions
+type offsetMover interface {
+	MarkOffset(topic string, partition int32, offset int64, metadata string)
+	ResetOffset(topic string, partition int32, offset int64, metadata string)
+}
+
+// moveOffset moves the offset of a partition, forward or backward from the
+// committed one: the session only marks offsets forward, and only resets
+// them backward
+func moveOffset(session offsetMover, topic string, partition int32, offset int64) {
+	session.MarkOffset(topic, partition, offset, "")
+	session.ResetOffset(topic, partition, offset, "")
+}
+
+func (c *groupConsumer) Setup(session sarama.ConsumerGroupSession) error {
+	logger.Info("Joined generation %d of group [%s] of [%s], partitions: %v",
+		session.GenerationID(), c.broker.Group, c.broker.Name, session.Claims())
+	c.setConnected(true)
+	c.retry.reset()
+
+	c.mu.Lock()
+	client := c.client
+	c.mu.Unlock()
+	for topic, partitions := range session.Claims() {
+		if h := c.handler(topic); h != nil {
+			positionPartitions(session, client, c.broker.Group, h, partitions)
+		}
+	}
+	return nil
+}
+
+// positionPartitions moves the claimed partitions the group never committed
+// an offset for to the start_offset of their topic. The others resume from
+// the committed offset, whichever member consumed them before.
+func positionPartitions(session offsetMover, client sarama.Client, group string, h *topicHandler, partitions []int32) {
+	if h.topic.StartOffset == startOffsetCommitted {
+		return
+	}
+	committed, err := committedOffsets(client, group, h.topic.Name, partitions)
+	if err != nil {
+		// not fatal, the partitions resume from the committed offset
+		logger.Error("Cannot get the committed offsets of %s: %s", h.topic.Name, err)
+		return
+	}
+	for _, partition := range partitions {
+		if _, ok := committed[partition]; ok {
+			continue
+		}
+		offset, ok, err := h.startOffset(client, partition)
+		if err != nil {
+			logger.Error("Cannot get %s offset of %s[%d]: %s", h.topic.StartOffset, h.topic.Name, partition, err)
+			continue
+		}
+		if ok {
+			logger.Info("Starting %s[%d] from %s offset %d", h.topic.Name, partition, h.topic.StartOffset, offset)
+			moveOffset(session, h.topic.Name, partition, offset)
 		}
+	}
+}
+
+// committedOffsets returns the offsets the group committed for partitions
+// of a topic, the partitions without committed offset are missing
+func committedOffsets(client sarama.Client, group string, topic string, partitions []int32) (map[int32]int64, error) {
+	coordinator, err := client.Coordinator(group)
+	if err != nil {
+		return nil, err
+	}
+	request := &sarama.OffsetFetchRequest{ConsumerGroup: group}
+	// version 0 fetches the offsets from zookeeper, as the group commits
+	// them to kafka from 0.8.2
+	if client.Config().Version.IsAtLeast(sarama.V0_8_2_0) {
+		request.Version = 1
+	}
+	for _, partition := range partitions {
+		request.AddPartition(topic, partition)
+	}
+	response, err := coordinator.FetchOffset(request)
+	if err != nil {
+		return nil, err
+	}
+
+	committed := make(map[int32]int64)
+	for _, partition := range partitions {
+		block := response.GetBlock(topic, partition)
+		if block == nil {
+			continue
+		}
+		if block.Err != sarama.ErrNoError {
+			return nil, block.Err
+		}
+		if block.Offset >= 0 {
+			committed[partition] = block.Offset
+		}
+	}
+	return committed, nil
+}
+
+func (c *groupConsumer) Cleanup(session sarama.ConsumerGroupSession) error {
+	logger.Debug("Leaving generation %d of group [%s] of [%s]", session.GenerationID(), c.broker.Group, c.broker.Name)
+	for topic, partitions := range session.Claims() {
+		forgetPartitions(c.broker.Name, topic, partitions)
+	}
+	return nil
+}
+
+func (c *groupConsumer) ConsumeClaim(session sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) error {
+	// the topic was removed meanwhile, the session is ending
+	h := c.handler(claim.Topic())
+	if h == nil {
+		return nil
+	}
+	c.mu.Lock()
+	client := c.client
+	c.mu.Unlock()
+
+	// the lag must not be refreshed once the partition is released
+	next := claim.InitialOffset()
+	stop := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		refreshLag(stop, client, c.broker, claim, &next)
+	}()
+	defer func() {
+		close(stop)
+		<-stopped
 	}()
-	<-doneCh
+
+	for msg := range claim.Messages() {
+		logger.Debug("Message on %s[%d]@%d: %s", msg.Topic, msg.Partition, msg.Offset, string(msg.Value))
+		m := &Message{
+			Cluster:   c.broker.Name,
+			Topic:     msg.Topic,
+			Timestamp: msg.Timestamp,
+			Value:     msg.Value,
+		}
+		start := time.Now()
+		err := export(h.decoder, m)
+		observeMessage(c.broker, claim, msg, time.Since(start))
+		if err != nil {
+			// skip the message, otherwise it would be consumed again and
+			// again after every restart
+			logger.Error("Skipping message %s[%d]@%d: %s", msg.Topic, msg.Partition, msg.Offset, err)
+			decodeErrors.WithLabelValues(c.broker.Name, msg.Topic, errorReason(err)).Inc()
+			c.deadLetters.send(msg, h.decoder, err)
+		}
+		session.MarkMessage(msg, "")
+		atomic.StoreInt64(&next, msg.Offset+1)
+	}
+	return nil
 }
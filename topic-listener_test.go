@@ -0,0 +1,138 @@
+// Copyright 2019 Open Networking Foundation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+)
+
+const testTopic = "voltha.kpis"
+
+// partitionSession moves the offsets of a partition offset manager, as the
+// group session does
+type partitionSession struct {
+	pom sarama.PartitionOffsetManager
+}
+
+func (s partitionSession) MarkOffset(topic string, partition int32, offset int64, metadata string) {
+	s.pom.MarkOffset(offset, metadata)
+}
+
+func (s partitionSession) ResetOffset(topic string, partition int32, offset int64, metadata string) {
+	s.pom.ResetOffset(offset, metadata)
+}
+
+// committedPartition returns the offset manager of a partition whose group
+// committed offset 100
+func committedPartition(t *testing.T) (sarama.PartitionOffsetManager, func()) {
+	broker := sarama.NewMockBroker(t, 1)
+	broker.SetHandlerByMap(map[string]sarama.MockResponse{
+		"MetadataRequest": sarama.NewMockMetadataResponse(t).
+			SetBroker(broker.Addr(), broker.BrokerID()).
+			SetLeader(testTopic, 0, broker.BrokerID()),
+		"FindCoordinatorRequest": sarama.NewMockFindCoordinatorResponse(t).
+			SetCoordinator(sarama.CoordinatorGroup, "group", broker),
+		"OffsetFetchRequest": sarama.NewMockOffsetFetchResponse(t).
+			SetOffset("group", testTopic, 0, 100, "", sarama.ErrNoError),
+		"OffsetCommitRequest": sarama.NewMockOffsetCommitResponse(t),
+	})
+
+	config := sarama.NewConfig()
+	config.Version = sarama.V1_0_0_0
+	config.Consumer.Offsets.CommitInterval = 10 * time.Millisecond
+	client, err := sarama.NewClient([]string{broker.Addr()}, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	om, err := sarama.NewOffsetManagerFromClient("group", client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pom, err := om.ManagePartition(testTopic, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pom, func() {
+		pom.Close()
+		om.Close()
+		client.Close()
+		broker.Close()
+	}
+}
+
+func TestMoveOffsetWithCommit(t *testing.T) {
+	for _, offset := range []int64{150, 50, 100} {
+		pom, closeAll := committedPartition(t)
+		if next, _ := pom.NextOffset(); next != 100 {
+			t.Fatalf("committed offset = %d, want 100", next)
+		}
+		moveOffset(partitionSession{pom}, testTopic, 0, offset)
+		if next, _ := pom.NextOffset(); next != offset {
+			t.Errorf("moved to %d, next offset = %d", offset, next)
+		}
+		closeAll()
+	}
+}
+
+// recordingSession records the offsets the partitions are moved to
+type recordingSession map[int32]int64
+
+func (s recordingSession) MarkOffset(topic string, partition int32, offset int64, metadata string) {
+	s[partition] = offset
+}
+
+func (s recordingSession) ResetOffset(topic string, partition int32, offset int64, metadata string) {
+	s[partition] = offset
+}
+
+func TestPositionPartitionsKeepsCommittedOffset(t *testing.T) {
+	broker := sarama.NewMockBroker(t, 1)
+	defer broker.Close()
+	broker.SetHandlerByMap(map[string]sarama.MockResponse{
+		"MetadataRequest": sarama.NewMockMetadataResponse(t).
+			SetBroker(broker.Addr(), broker.BrokerID()).
+			SetLeader(testTopic, 0, broker.BrokerID()).
+			SetLeader(testTopic, 1, broker.BrokerID()),
+		"FindCoordinatorRequest": sarama.NewMockFindCoordinatorResponse(t).
+			SetCoordinator(sarama.CoordinatorGroup, "group", broker),
+		// partition 1 was never committed
+		"OffsetFetchRequest": sarama.NewMockOffsetFetchResponse(t).
+			SetOffset("group", testTopic, 0, 100, "", sarama.ErrNoError).
+			SetOffset("group", testTopic, 1, -1, "", sarama.ErrNoError),
+		"OffsetRequest": sarama.NewMockOffsetResponse(t).
+			SetOffset(testTopic, 0, sarama.OffsetOldest, 10).
+			SetOffset(testTopic, 1, sarama.OffsetOldest, 10),
+	})
+
+	config := sarama.NewConfig()
+	config.Version = sarama.V1_0_0_0
+	client, err := sarama.NewClient([]string{broker.Addr()}, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	// the handler of a member the partitions were just moved to
+	h := newTopicHandler(TopicInfo{Name: testTopic, StartOffset: startOffsetOldest})
+	session := make(recordingSession)
+	positionPartitions(session, client, "group", h, []int32{0, 1})
+	if want := (recordingSession{1: 10}); !reflect.DeepEqual(session, want) {
+		t.Errorf("moved partitions %v, want %v", session, want)
+	}
+}
@@ -14,30 +14,184 @@
 
 package main
 
+import "encoding/json"
+
 // configuration
 type BrokerInfo struct {
-	Name			string `yaml: name`
-	Host			string `yaml: host`
-	Description		string `yaml: description`
-	Topics		  []string `yaml: topics`
+	Name string `yaml:"name"`
+	// bootstrap brokers, Host is kept for backward compatibility
+	Hosts       []string    `yaml:"hosts"`
+	Host        string      `yaml:"host"`
+	Description string      `yaml:"description"`
+	Group       string      `yaml:"group"`
+	Version     string      `yaml:"version"`
+	TLS         TLSInfo     `yaml:"tls"`
+	SASL        SASLInfo    `yaml:"sasl"`
+	Topics      []TopicInfo `yaml:"topics"`
+	// how often topic patterns are matched against the cluster topics
+	DiscoveryInterval string `yaml:"discovery_interval"`
+	// where messages which cannot be decoded are forwarded, if set
+	DeadLetterTopic string `yaml:"dead_letter_topic"`
+	// lag of a partition above which the exporter is not ready, 0 never
+	MaxLag int64 `yaml:"max_lag"`
+}
+
+type TLSInfo struct {
+	Enabled            bool   `yaml:"enabled"`
+	CAFile             string `yaml:"ca_file"`
+	CertFile           string `yaml:"cert_file"`
+	KeyFile            string `yaml:"key_file"`
+	InsecureSkipVerify bool   `yaml:"insecure_skip_verify"`
+}
+
+type SASLInfo struct {
+	Enabled bool `yaml:"enabled"`
+	// PLAIN, SCRAM-SHA-256 or SCRAM-SHA-512
+	Mechanism string `yaml:"mechanism"`
+	Username  string `yaml:"username"`
+	Password  string `yaml:"password"`
+}
+
+type TopicInfo struct {
+	Name string `yaml:"name"`
+	// regular expression matched against the topics of the cluster,
+	// instead of a name
+	Pattern     string `yaml:"pattern"`
+	StartOffset string `yaml:"start_offset"`
+	// defaults to the decoder of the topic name
+	Decoder string `yaml:"decoder"`
+}
+
+// UnmarshalYAML allows topics without settings to be listed by name only
+func (t *TopicInfo) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	if err := unmarshal(&t.Name); err == nil {
+		return nil
+	}
+	type plain TopicInfo
+	return unmarshal((*plain)(t))
 }
 
 type LoggerInfo struct {
-	LogLevel		string `yaml: loglevel`
-	Host			string `yaml: host`
+	LogLevel string   `yaml:"loglevel"`
+	Host     string   `yaml:"host"`
+	TLS      TLSInfo  `yaml:"tls"`
+	SASL     SASLInfo `yaml:"sasl"`
 }
 
 type TargetInfo struct {
-	Type			string `yaml: type`
-	Name			string `yaml: name`
-	Port			int    `yaml: port`
-	Description		string `yaml: description`
+	Type        string `yaml:"type"`
+	Name        string `yaml:"name"`
+	Port        int    `yaml:"port"`
+	Description string `yaml:"description"`
+	// export the samples with the timestamp of their KPI
+	Timestamps bool `yaml:"timestamps"`
+}
+
+// MappingInfo declares a decoder turning JSON messages into metrics
+type MappingInfo struct {
+	// name of the decoder, used by the topics configuration
+	Decoder string              `yaml:"decoder"`
+	Metrics []MetricMappingInfo `yaml:"metrics"`
+}
+
+type MetricMappingInfo struct {
+	Name string `yaml:"name"`
+	// gauge (default), counter or untyped
+	Type string `yaml:"type"`
+	Help string `yaml:"help"`
+	// path of the records of the message, each record is a sample
+	Records string `yaml:"records"`
+	// label name -> path of the label value
+	Labels map[string]string `yaml:"labels"`
+	// path of the sample value
+	Value string `yaml:"value"`
+}
+
+type StateInfo struct {
+	// where the ONU PM history counters are saved, so that restarts
+	// neither lose nor double count them
+	File         string `yaml:"file"`
+	SaveInterval string `yaml:"save_interval"`
+}
+
+type ExpiryInfo struct {
+	// TTL of the families not listed, series never expire if unset
+	DefaultTTL string `yaml:"default_ttl"`
+	// metric family -> TTL
+	TTL map[string]string `yaml:"ttl"`
+	// how often expired series are deleted
+	Interval string `yaml:"interval"`
+}
+
+// RttInfo configures the distribution of the AAA round trip times
+type RttInfo struct {
+	// histogram (default) or summary
+	Type string `yaml:"type"`
+	// histogram buckets, in milliseconds
+	Buckets []float64 `yaml:"buckets"`
+	// summary quantiles -> allowed error
+	Objectives map[float64]float64 `yaml:"objectives"`
+}
+
+// LimitsInfo bounds the number of series of the metric families
+type LimitsInfo struct {
+	// maximum number of series of the families not listed in max_series,
+	// 0 unlimited
+	DefaultMaxSeries int `yaml:"default_max_series"`
+	// by family name
+	MaxSeries map[string]int `yaml:"max_series"`
+	// maximum number of series of all the families together, 0 unlimited
+	MaxTotalSeries int `yaml:"max_total_series"`
+	// drop (default) or fold the new series once a limit is reached
+	Overflow string `yaml:"overflow"`
+}
+
+// RelabelInfo is a Prometheus relabel_config, applied to the series of every
+// metric family before they are created
+type RelabelInfo struct {
+	SourceLabels []string `yaml:"source_labels"`
+	// defaults to ;
+	Separator *string `yaml:"separator"`
+	// anchored, defaults to (.*)
+	Regex       string `yaml:"regex"`
+	TargetLabel string `yaml:"target_label"`
+	// defaults to $1
+	Replacement *string `yaml:"replacement"`
+	Modulus     uint64  `yaml:"modulus"`
+	// replace (default), keep, drop, hashmod, labelmap, labeldrop or labelkeep
+	Action string `yaml:"action"`
 }
 
 type Config struct {
-	Broker		BrokerInfo `yaml: broker`
-	Logger		LoggerInfo `yaml: logger`
-	Target		TargetInfo `yaml: "target"`
+	Brokers []BrokerInfo `yaml:"brokers"`
+	// single broker, kept for backward compatibility
+	Broker BrokerInfo `yaml:"broker"`
+	Logger LoggerInfo `yaml:"logger"`
+	Target TargetInfo `yaml:"target"`
+	// metrics extracted from JSON messages by configuration
+	Mappings []MappingInfo `yaml:"mappings"`
+	State    StateInfo     `yaml:"state"`
+	Expiry   ExpiryInfo    `yaml:"expiry"`
+	AAARtt   RttInfo       `yaml:"aaa_rtt"`
+	// applied in order, the metric family name is the __name__ label
+	RelabelConfigs []RelabelInfo `yaml:"relabel_configs"`
+	Limits         LimitsInfo    `yaml:"limits"`
+}
+
+// bootstrapHosts returns all the configured bootstrap brokers
+func (b BrokerInfo) bootstrapHosts() []string {
+	if b.Host == "" {
+		return b.Hosts
+	}
+	return append([]string{b.Host}, b.Hosts...)
+}
+
+// brokers returns all the configured brokers
+func (c Config) brokers() []BrokerInfo {
+	if len(c.Broker.bootstrapHosts()) == 0 {
+		return c.Brokers
+	}
+	return append([]BrokerInfo{c.Broker}, c.Brokers...)
 }
 
 // KPI Events format
@@ -52,11 +206,46 @@ type Metrics struct {
 	RxPackets          float64 `json:"rx_packets"`
 	RxErrorPackets     float64 `json:"rx_error_packets"`
 	RxBcastPackets     float64 `json:"rx_bcast_packets"`
+	RxUnicastPackets   float64 `json:"rx_ucast_packets"`
 	RxMulticastPackets float64 `json:"rx_mcast_packets"`
 
 	// ONU Ethernet_Bridge_Port_history
-	Packets            float64 `json:"packets"`
-	Octets             float64 `json:"octets"`
+	Packets float64 `json:"packets"`
+	Octets  float64 `json:"octets"`
+
+	// ONU FEC_History
+	CorrectedBytes         float64 `json:"corrected_bytes"`
+	CorrectedCodeWords     float64 `json:"corrected_code_words"`
+	UncorrectableCodeWords float64 `json:"uncorrectable_code_words"`
+	TotalCodeWords         float64 `json:"total_code_words"`
+	FecSeconds             float64 `json:"fec_seconds"`
+
+	// ONU Ethernet_UNI_History
+	FcsErrors                      float64 `json:"fcs_errors"`
+	ExcessiveCollisionCounter      float64 `json:"excessive_collision_counter"`
+	LateCollisionCounter           float64 `json:"late_collision_counter"`
+	FramesTooLong                  float64 `json:"frames_too_long"`
+	BufferOverflowsOnRx            float64 `json:"buffer_overflows_on_rx"`
+	BufferOverflowsOnTx            float64 `json:"buffer_overflows_on_tx"`
+	SingleCollisionFrameCounter    float64 `json:"single_collision_frame_counter"`
+	MultipleCollisionsFrameCounter float64 `json:"multiple_collisions_frame_counter"`
+	SqeCounter                     float64 `json:"sqe_counter"`
+	DeferredTxCounter              float64 `json:"deferred_tx_counter"`
+	InternalMacTxErrorCounter      float64 `json:"internal_mac_tx_error_counter"`
+	CarrierSenseErrorCounter       float64 `json:"carrier_sense_error_counter"`
+	AlignmentErrorCounter          float64 `json:"alignment_error_counter"`
+	InternalMacRxErrorCounter      float64 `json:"internal_mac_rx_error_counter"`
+
+	// ONU PON_Optical, nil if not reported as 0 is a valid level
+	TxPowerDbm       *float64 `json:"transmit_power_dBm"`
+	RxPowerDbm       *float64 `json:"receive_power_dBm"`
+	LaserBiasCurrent *float64 `json:"laser_bias_current"`
+	SupplyVoltage    *float64 `json:"voltage"`
+	Temperature      *float64 `json:"temperature"`
+
+	// ONU PM history entity and interval, nil if not reported
+	EntityID        *float64 `json:"entity_id"`
+	IntervalEndTime *float64 `json:"interval_end_time"`
 }
 
 type Context struct {
@@ -64,10 +253,13 @@ type Context struct {
 	PonID       string `json:"pon_id"`
 	PortNumber  string `json:"port_no"`
 
+	// voltha.internal
+	InstanceID string `json:"instance_id"`
+
 	// ONU Performance Metrics
-	ParentClassId string `json:"parent_class_id"`
+	ParentClassId  string `json:"parent_class_id"`
 	ParentEntityId string `json:"parent_entity_id"`
-	Upstream    string `json:"upstream"`
+	Upstream       string `json:"upstream"`
 }
 
 type Metadata struct {
@@ -82,6 +274,39 @@ type Metadata struct {
 type SliceData struct {
 	Metrics  *Metrics  `json:"metrics"`
 	Metadata *Metadata `json:"metadata"`
+
+	// the metrics as received, kept for the titles whose metrics vary, e.g.
+	// voltha.internal
+	raw json.RawMessage
+}
+
+// UnmarshalJSON decodes the metadata, then the metrics
+func (d *SliceData) UnmarshalJSON(data []byte) error {
+	var slice struct {
+		Metrics  json.RawMessage `json:"metrics"`
+		Metadata *Metadata       `json:"metadata"`
+	}
+	if err := json.Unmarshal(data, &slice); err != nil {
+		return err
+	}
+	d.Metadata = slice.Metadata
+	return d.setMetrics(slice.Metrics)
+}
+
+// setMetrics decodes the known metrics, and keeps the metrics undecoded if
+// they vary with the title of the metadata
+func (d *SliceData) setMetrics(raw json.RawMessage) error {
+	d.Metrics, d.raw = nil, nil
+	if len(raw) == 0 {
+		return nil
+	}
+	if err := json.Unmarshal(raw, &d.Metrics); err != nil {
+		return err
+	}
+	if d.Metadata != nil && d.Metadata.Title == "voltha.internal" {
+		d.raw = raw
+	}
+	return nil
 }
 
 type VolthaKPI struct {
@@ -105,12 +330,69 @@ type OnosKPI struct {
 	Ports    []*OnosPort `json:"ports"`
 }
 
+// Redfish importer KPIs, readings of the Thermal, Power, Processors and
+// Memory resources of a device
+type ImporterSensor struct {
+	Name string `json:"name"`
+	// e.g. the Redfish PhysicalContext of temperatures: CPU, Intake...
+	Type    string  `json:"type"`
+	Reading float64 `json:"reading"`
+	// RPM or Percent, for fans
+	Units string `json:"units"`
+}
+
+type ImporterPowerSupply struct {
+	Name         string  `json:"name"`
+	Type         string  `json:"type"`
+	InputWatts   float64 `json:"powerInputWatts"`
+	OutputWatts  float64 `json:"powerOutputWatts"`
+	InputVoltage float64 `json:"lineInputVoltage"`
+	// OK, Warning or Critical
+	Health string `json:"health"`
+}
+
+type ImporterProcessor struct {
+	Name               string  `json:"name"`
+	Type               string  `json:"type"`
+	UtilizationPercent float64 `json:"utilizationPercent"`
+}
+
+type ImporterMemory struct {
+	Name       string  `json:"name"`
+	Type       string  `json:"type"`
+	UsedBytes  float64 `json:"usedBytes"`
+	TotalBytes float64 `json:"totalBytes"`
+}
+
 type ImporterKPI struct {
-	DeviceID string 	`json: "deviceId"`
-	// TODO: add metrics data
+	DeviceID      string                 `json:"deviceId"`
+	Temperatures  []*ImporterSensor      `json:"temperatures"`
+	Fans          []*ImporterSensor      `json:"fans"`
+	PowerSupplies []*ImporterPowerSupply `json:"powerSupplies"`
+	Processors    []*ImporterProcessor   `json:"processors"`
+	Memory        []*ImporterMemory      `json:"memory"`
+}
+
+// state machine statistics of the supplicants of a device port
+type OnosAaaPortStats struct {
+	DeviceID              string  `json:"deviceId"`
+	PortNumber            string  `json:"portNumber"`
+	EapolLogoffRx         float64 `json:"eapolLogoffRx"`
+	EapolAuthSuccessTrans float64 `json:"eapolAuthSuccessTrans"`
+	EapolAuthFailureTrans float64 `json:"eapolAuthFailureTrans"`
+	EapolStartReqTrans    float64 `json:"eapolStartReqTrans"`
+	EapolFramesTx         float64 `json:"eapolFramesTx"`
+	ValidEapolFramesRx    float64 `json:"validEapolFramesRx"`
+	PendingResSupp        float64 `json:"pendingResSupp"`
+	AuthStateIdle         float64 `json:"authStateIdle"`
 }
 
 type OnosAaaKPI struct {
+	// ONOS instance publishing the statistics, some releases only
+	// publish the cluster node
+	InstanceID  string `json:"instanceId"`
+	ClusterNode string `json:"clusterNode"`
+
 	RxAcceptResponses    float64 `json:"acceptResponsesRx"`
 	RxRejectResponses    float64 `json:"rejectResponsesRx"`
 	RxChallengeResponses float64 `json:"challengeResponsesRx"`
@@ -123,4 +405,6 @@ type OnosAaaKPI struct {
 	RxUnknownserver      float64 `json:"unknownServerRx"`
 	RequestRttMillis     float64 `json:"requestRttMillis"`
 	RequestReTx          float64 `json:"requestReTx"`
+
+	Ports []*OnosAaaPortStats `json:"ports"`
 }
@@ -0,0 +1,233 @@
+// Copyright 2019 Open Networking Foundation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+
+	"github.com/golang/protobuf/jsonpb"
+	"github.com/golang/protobuf/proto"
+)
+
+// VOLTHA 2.x KPI events, as defined in voltha-protos events.proto. Only the
+// fields the exporter needs are declared, the others are skipped when
+// decoding.
+
+type KpiEventType int32
+
+const (
+	KpiEventType_slice KpiEventType = 0
+	KpiEventType_ts    KpiEventType = 1
+)
+
+var KpiEventType_name = map[int32]string{
+	0: "slice",
+	1: "ts",
+}
+
+var KpiEventType_value = map[string]int32{
+	"slice": 0,
+	"ts":    1,
+}
+
+func (x KpiEventType) String() string {
+	return proto.EnumName(KpiEventType_name, int32(x))
+}
+
+type MetricMetaData struct {
+	Title           string            `protobuf:"bytes,1,opt,name=title,proto3" json:"title,omitempty"`
+	Ts              float64           `protobuf:"fixed64,2,opt,name=ts,proto3" json:"ts,omitempty"`
+	LogicalDeviceId string            `protobuf:"bytes,3,opt,name=logical_device_id,json=logicalDeviceId,proto3" json:"logical_device_id,omitempty"`
+	SerialNo        string            `protobuf:"bytes,4,opt,name=serial_no,json=serialNo,proto3" json:"serial_no,omitempty"`
+	DeviceId        string            `protobuf:"bytes,5,opt,name=device_id,json=deviceId,proto3" json:"device_id,omitempty"`
+	Context         map[string]string `protobuf:"bytes,6,rep,name=context,proto3" json:"context,omitempty" protobuf_key:"bytes,1,opt,name=key,proto3" protobuf_val:"bytes,2,opt,name=value,proto3"`
+}
+
+func (m *MetricMetaData) Reset()         { *m = MetricMetaData{} }
+func (m *MetricMetaData) String() string { return proto.CompactTextString(m) }
+func (*MetricMetaData) ProtoMessage()    {}
+
+// MetricInformation carries the metrics as float32, so counters above 2^24
+// lose their lowest digits before they reach the exporter
+type MetricInformation struct {
+	Metadata *MetricMetaData    `protobuf:"bytes,1,opt,name=metadata,proto3" json:"metadata,omitempty"`
+	Metrics  map[string]float32 `protobuf:"bytes,2,rep,name=metrics,proto3" json:"metrics,omitempty" protobuf_key:"bytes,1,opt,name=key,proto3" protobuf_val:"fixed32,2,opt,name=value,proto3"`
+}
+
+func (m *MetricInformation) Reset()         { *m = MetricInformation{} }
+func (m *MetricInformation) String() string { return proto.CompactTextString(m) }
+func (*MetricInformation) ProtoMessage()    {}
+
+type KpiEvent2 struct {
+	Type      KpiEventType         `protobuf:"varint,1,opt,name=type,proto3,enum=voltha.KpiEventType" json:"type,omitempty"`
+	Ts        float64              `protobuf:"fixed64,2,opt,name=ts,proto3" json:"ts,omitempty"`
+	SliceData []*MetricInformation `protobuf:"bytes,3,rep,name=slice_data,json=sliceData,proto3" json:"slice_data,omitempty"`
+}
+
+func (m *KpiEvent2) Reset()         { *m = KpiEvent2{} }
+func (m *KpiEvent2) String() string { return proto.CompactTextString(m) }
+func (*KpiEvent2) ProtoMessage()    {}
+
+type EventHeader struct {
+	Id string `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+}
+
+func (m *EventHeader) Reset()         { *m = EventHeader{} }
+func (m *EventHeader) String() string { return proto.CompactTextString(m) }
+func (*EventHeader) ProtoMessage()    {}
+
+// Event is the envelope of the voltha.events topic, kpi_event2 is part of
+// the event_type oneof which is encoded as a regular field on the wire.
+// Field 3 of the oneof is the VOLTHA 1.x kpi_event, which is not decoded.
+type Event struct {
+	Header    *EventHeader `protobuf:"bytes,1,opt,name=header,proto3" json:"header,omitempty"`
+	KpiEvent2 *KpiEvent2   `protobuf:"bytes,4,opt,name=kpi_event2,json=kpiEvent2,proto3" json:"kpi_event2,omitempty"`
+}
+
+func (m *Event) Reset()         { *m = Event{} }
+func (m *Event) String() string { return proto.CompactTextString(m) }
+func (*Event) ProtoMessage()    {}
+
+// kpiEvent returns the KPI event of the envelope, other events, e.g. device
+// events, are published on the same topic and have no KPIs
+func (m *Event) kpiEvent() *KpiEvent2 {
+	if m.KpiEvent2 == nil {
+		return &KpiEvent2{}
+	}
+	return m.KpiEvent2
+}
+
+func init() {
+	proto.RegisterEnum("voltha.KpiEventType", KpiEventType_name, KpiEventType_value)
+}
+
+// decodeVolthaKPI decodes the VOLTHA 1.x JSON KPIs, and the VOLTHA 2.x
+// KpiEvent2, bare or in an Event, either protobuf or JSON encoded
+func decodeVolthaKPI(data []byte) (VolthaKPI, error) {
+	var event *KpiEvent2
+	var err error
+
+	trimmed := bytes.TrimSpace(data)
+	if len(trimmed) > 0 && trimmed[0] == '{' {
+		event, err = unmarshalKpiEventJSON(trimmed)
+		if event == nil && err == nil {
+			// VOLTHA 1.x format
+			kpi := VolthaKPI{}
+			err = json.Unmarshal(data, &kpi)
+			return kpi, err
+		}
+	} else {
+		event, err = unmarshalKpiEvent(data)
+	}
+	if err != nil {
+		return VolthaKPI{}, err
+	}
+	return event.volthaKPI()
+}
+
+// unmarshalKpiEventJSON decodes the JSON rendering of a KpiEvent2, it
+// returns nil without error for the VOLTHA 1.x format
+func unmarshalKpiEventJSON(data []byte) (*KpiEvent2, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+
+	unmarshaler := jsonpb.Unmarshaler{AllowUnknownFields: true}
+	_, camel := fields["kpiEvent2"]
+	_, orig := fields["kpi_event2"]
+	if camel || orig {
+		envelope := &Event{}
+		if err := unmarshaler.Unmarshal(bytes.NewReader(data), envelope); err != nil {
+			return nil, err
+		}
+		return envelope.kpiEvent(), nil
+	}
+
+	// a KpiEvent2 rendered with the original field names has the same
+	// layout as the VOLTHA 1.x format
+	if _, ok := fields["sliceData"]; !ok {
+		return nil, nil
+	}
+	event := &KpiEvent2{}
+	return event, unmarshaler.Unmarshal(bytes.NewReader(data), event)
+}
+
+// unmarshalKpiEvent decodes a protobuf KpiEvent2, bare or in an Event.
+// Both decode without error as the other, as unknown fields and fields of
+// another wire type are skipped, so the message is decoded as an Event
+// first: an Event has a header, which a KpiEvent2 has not.
+func unmarshalKpiEvent(data []byte) (*KpiEvent2, error) {
+	envelope := &Event{}
+	if err := proto.Unmarshal(data, envelope); err != nil {
+		return nil, err
+	}
+	if envelope.KpiEvent2 != nil || envelope.Header != nil {
+		return envelope.kpiEvent(), nil
+	}
+	event := &KpiEvent2{}
+	return event, proto.Unmarshal(data, event)
+}
+
+// volthaKPI converts the event to the VOLTHA 1.x format, so that both
+// produce the same metrics
+func (e *KpiEvent2) volthaKPI() (VolthaKPI, error) {
+	kpi := VolthaKPI{
+		Type:      e.Type.String(),
+		Timestamp: e.Ts,
+	}
+	for _, info := range e.SliceData {
+		data := &SliceData{
+			Metadata: &Metadata{Context: &Context{}},
+		}
+		if md := info.Metadata; md != nil {
+			data.Metadata.Title = md.Title
+			data.Metadata.Timestamp = md.Ts
+			data.Metadata.LogicalDeviceID = md.LogicalDeviceId
+			data.Metadata.SerialNumber = md.SerialNo
+			data.Metadata.DeviceID = md.DeviceId
+			if err := remarshal(md.Context, data.Metadata.Context); err != nil {
+				return kpi, err
+			}
+		}
+		// the float32 metrics are rendered with the shortest decimal that
+		// round trips, so that e.g. 0.1 is exported as 0.1 rather than as
+		// 0.10000000149011612
+		metrics := info.Metrics
+		if metrics == nil {
+			metrics = map[string]float32{}
+		}
+		b, err := json.Marshal(metrics)
+		if err != nil {
+			return kpi, err
+		}
+		if err := data.setMetrics(b); err != nil {
+			return kpi, err
+		}
+		kpi.SliceDatas = append(kpi.SliceDatas, data)
+	}
+	return kpi, nil
+}
+
+// remarshal fills the JSON tagged struct from the map, the keys of the
+// KpiEvent2 maps are the JSON names of the VOLTHA 1.x format
+func remarshal(from interface{}, to interface{}) error {
+	b, err := json.Marshal(from)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, to)
+}
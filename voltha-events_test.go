@@ -0,0 +1,121 @@
+// Copyright 2019 Open Networking Foundation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"testing"
+
+	"github.com/golang/protobuf/jsonpb"
+	"github.com/golang/protobuf/proto"
+)
+
+const volthaKPI1x = `{
+	"type": "slice",
+	"ts": 0,
+	"slice_data": [{
+		"metrics": {"tx_bytes": 16777217, "rx_packets": 0.1},
+		"metadata": {
+			"title": "Ethernet",
+			"ts": 1536617075.5,
+			"logical_device_id": "ld-1",
+			"serial_no": "BBSM00000001",
+			"device_id": "olt-1",
+			"context": {"port_no": "16"}
+		}
+	}]
+}`
+
+func TestDecodeVolthaKPI(t *testing.T) {
+	// the defaults of type and ts are not on the wire, so that the
+	// KpiEvent2 only has slice_data
+	event := &KpiEvent2{
+		SliceData: []*MetricInformation{{
+			Metadata: &MetricMetaData{
+				Title:           "Ethernet",
+				Ts:              1536617075.5,
+				LogicalDeviceId: "ld-1",
+				SerialNo:        "BBSM00000001",
+				DeviceId:        "olt-1",
+				Context:         map[string]string{"port_no": "16"},
+			},
+			Metrics: map[string]float32{"tx_bytes": 16777217, "rx_packets": 0.1},
+		}},
+	}
+	envelope := &Event{Header: &EventHeader{Id: "Voltha.openolt.KPI_EVENT2.1"}, KpiEvent2: event}
+
+	bare, err := proto.Marshal(event)
+	if err != nil {
+		t.Fatal(err)
+	}
+	enveloped, err := proto.Marshal(envelope)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bareJSON, err := (&jsonpb.Marshaler{}).MarshalToString(event)
+	if err != nil {
+		t.Fatal(err)
+	}
+	envelopedJSON, err := (&jsonpb.Marshaler{OrigName: true}).MarshalToString(envelope)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range []struct {
+		format string
+		data   []byte
+		// 16777217 is rounded by the float32 of KpiEvent2
+		txBytes float64
+	}{
+		{"1.x JSON", []byte(volthaKPI1x), 16777217},
+		{"bare protobuf", bare, 16777216},
+		{"enveloped protobuf", enveloped, 16777216},
+		{"bare JSON", []byte(bareJSON), 16777216},
+		{"enveloped JSON", []byte(envelopedJSON), 16777216},
+	} {
+		kpi, err := decodeVolthaKPI(c.data)
+		if err != nil {
+			t.Errorf("%s: %s", c.format, err)
+			continue
+		}
+		if kpi.Type != "slice" || len(kpi.SliceDatas) != 1 {
+			t.Errorf("%s: type %s with %d slices, want slice with 1", c.format, kpi.Type, len(kpi.SliceDatas))
+			continue
+		}
+		data := kpi.SliceDatas[0]
+		md := data.Metadata
+		if md.Title != "Ethernet" || md.Timestamp != 1536617075.5 || md.LogicalDeviceID != "ld-1" ||
+			md.SerialNumber != "BBSM00000001" || md.DeviceID != "olt-1" || md.Context.PortNumber != "16" {
+			t.Errorf("%s: metadata %+v, context %+v", c.format, md, md.Context)
+		}
+		if data.Metrics.TxBytes != c.txBytes || data.Metrics.RxPackets != 0.1 {
+			t.Errorf("%s: tx_bytes %v, rx_packets %v, want %v, 0.1", c.format, data.Metrics.TxBytes, data.Metrics.RxPackets, c.txBytes)
+		}
+	}
+}
+
+func TestDecodeVolthaOtherEvent(t *testing.T) {
+	// a device event, field 5 of the Event oneof
+	data, err := proto.Marshal(&Event{Header: &EventHeader{Id: "Voltha.openolt.DEVICE_EVENT.1"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data = append(data, proto.EncodeVarint(5<<3|proto.WireBytes)...)
+	data = append(data, 0)
+
+	kpi, err := decodeVolthaKPI(data)
+	if err != nil || len(kpi.SliceDatas) != 0 {
+		t.Errorf("decodeVolthaKPI = %+v, %v, want no slices", kpi, err)
+	}
+}
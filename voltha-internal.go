@@ -0,0 +1,108 @@
+// Copyright 2019 Open Networking Foundation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"sync"
+	"time"
+
+	"gerrit.opencord.org/kafka-topic-exporter/common/logger"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// maximum number of voltha.internal gauges, so that a misbehaving component
+// cannot create families without bound, their series are bounded by the
+// series limits
+const maxVolthaInternalGauges = 256
+
+// voltha.internal metrics, e.g. deferreds or rss-mb, vary between VOLTHA
+// releases so their gauges are created when first received
+var (
+	volthaInternalMu sync.Mutex
+	// by gauge name, nil if the gauge cannot be registered
+	volthaInternal = make(map[string]*gaugeVec)
+	// whether reaching maxVolthaInternalGauges was reported
+	volthaInternalFull bool
+)
+
+// volthaInternalName returns the name of the gauge of a metric, characters
+// not allowed in metric names are replaced by _
+func volthaInternalName(metric string) string {
+	name := strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_' || r == ':' {
+			return r
+		}
+		return '_'
+	}, metric)
+	return "voltha_internal_" + name
+}
+
+// volthaInternalGauge returns the gauge of a metric, creating it if needed
+func volthaInternalGauge(metric string) *gaugeVec {
+	name := volthaInternalName(metric)
+
+	volthaInternalMu.Lock()
+	defer volthaInternalMu.Unlock()
+
+	if g, ok := volthaInternal[name]; ok {
+		return g
+	}
+	if len(volthaInternal) >= maxVolthaInternalGauges {
+		if !volthaInternalFull {
+			logger.Error("Reached %d voltha.internal gauges, new metrics, e.g. [%s], are not exported",
+				maxVolthaInternalGauges, metric)
+			volthaInternalFull = true
+		}
+		return nil
+	}
+
+	g := newGaugeVec(
+		prometheus.GaugeOpts{
+			Name: name,
+			Help: "VOLTHA internal metric " + metric,
+		},
+		[]string{"cluster", "instance_id"},
+	)
+	if err := prometheus.Register(g); err != nil {
+		logger.Error("Cannot export voltha.internal metric [%s] as %s: %s", metric, name, err)
+		g = nil
+	}
+	volthaInternal[name] = g
+	return g
+}
+
+// exportVolthaInternal exports the numeric metrics of the slice, they are
+// only decoded here as they vary
+func exportVolthaInternal(cluster string, at time.Time, data *SliceData) {
+	var metrics map[string]interface{}
+	if err := json.Unmarshal(data.raw, &metrics); err != nil {
+		logger.Warn("Cannot decode voltha.internal metrics: %s", err)
+		return
+	}
+	for metric, value := range metrics {
+		v, ok := value.(float64)
+		if !ok {
+			continue
+		}
+		if g := volthaInternalGauge(metric); g != nil {
+			g.at(at).WithLabelValues(
+				cluster,
+				data.Metadata.Context.InstanceID,
+			).Set(v)
+		}
+	}
+}
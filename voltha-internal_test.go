@@ -0,0 +1,62 @@
+// Copyright 2019 Open Networking Foundation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestVolthaInternalMetrics(t *testing.T) {
+	defer removeTracker("voltha_internal_test_deferreds")
+	defer removeTracker("voltha_internal_test_rss_mb")
+	defer removeTracker("voltha_internal_tx_bytes")
+
+	var kpi VolthaKPI
+	if err := json.Unmarshal([]byte(`{"type": "slice", "ts": 1, "slice_data": [
+		{
+			"metrics": {"test-deferreds": 12, "test-rss-mb": 180.5, "test-version": "2.1", "tx_bytes": 3},
+			"metadata": {"title": "voltha.internal", "context": {"instance_id": "vcore-1"}}
+		},
+		{
+			"metrics": {"tx_bytes": 3},
+			"metadata": {"title": "Ethernet", "context": {}}
+		}
+	]}`), &kpi); err != nil {
+		t.Fatal(err)
+	}
+	internal, ethernet := kpi.SliceDatas[0], kpi.SliceDatas[1]
+	if internal.Metrics.TxBytes != 3 || ethernet.Metrics.TxBytes != 3 {
+		t.Errorf("TxBytes = %f and %f, want 3", internal.Metrics.TxBytes, ethernet.Metrics.TxBytes)
+	}
+	// only the metrics of voltha.internal are kept undecoded
+	if internal.raw == nil || ethernet.raw != nil {
+		t.Errorf("raw metrics %s and %s, want those of voltha.internal only", internal.raw, ethernet.raw)
+	}
+
+	exportVolthaInternal("voltha", time.Time{}, internal)
+	for metric, want := range map[string]float64{"test-deferreds": 12, "test-rss-mb": 180.5} {
+		metrics := gather(t, volthaInternalGauge(metric))
+		if len(metrics) != 1 || labelValue(metrics[0], "instance_id") != "vcore-1" || metrics[0].GetGauge().GetValue() != want {
+			t.Errorf("%s gathered %v, want %v", metric, metrics, want)
+		}
+	}
+	volthaInternalMu.Lock()
+	defer volthaInternalMu.Unlock()
+	if _, ok := volthaInternal[volthaInternalName("test-version")]; ok {
+		t.Error("non numeric metric exported")
+	}
+}